@@ -0,0 +1,113 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Decoder reads the samples of an audio file as 16 bit PCM.
+type Decoder interface {
+	// Format returns the format of the decoded samples.
+	Format() Format
+	// Duration returns how long the audio plays for.
+	Duration() time.Duration
+	// ReadSamples decodes samples into samples, returning how many were read.
+	// It returns io.EOF once all the samples have been read.
+	ReadSamples(samples []int16) (int, error)
+}
+
+// File is an audio file opened for decoding.
+type File struct {
+	Decoder
+	file *os.File
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.file.Close()
+}
+
+// OpenFile opens an audio file to decode it. The decoder is chosen by the file's
+// extension. WAV, MP3 and Ogg Vorbis files are supported.
+func OpenFile(path string) (*File, error) {
+	newDecoder, supported := decoders[strings.ToLower(filepath.Ext(path))]
+	if !supported {
+		return nil, fmt.Errorf("can't decode '%s' files", filepath.Ext(path))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := newDecoder(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not decode %s: %v", path, err)
+	}
+	return &File{Decoder: decoder, file: f}, nil
+}
+
+// Info describes the audio in a file as it is encoded, which isn't always how
+// it is decoded: MP3 files are decoded to two channels even when they are mono.
+type Info struct {
+	SampleRate int
+	Channels   int
+	Duration   time.Duration
+}
+
+// Probe reads the sample rate, channels and duration of an audio file.
+func Probe(path string) (Info, error) {
+	f, err := OpenFile(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	info := Info{
+		SampleRate: f.Format().SampleRate,
+		Channels:   f.Format().Channels,
+		Duration:   f.Duration(),
+	}
+	if _, isMP3 := f.Decoder.(*MP3Decoder); isMP3 {
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return info, err
+		}
+		if info.Channels, err = mp3Channels(f.file); err != nil {
+			return info, err
+		}
+	}
+	return info, nil
+}
+
+// CanDecode returns true if OpenFile supports files with the name's extension.
+func CanDecode(name string) bool {
+	_, supported := decoders[strings.ToLower(filepath.Ext(name))]
+	return supported
+}
+
+// decoders make the decoder for each file extension.
+var decoders = map[string]func(io.ReadSeeker) (Decoder, error){
+	".wav": func(r io.ReadSeeker) (Decoder, error) { return NewWAVDecoder(r) },
+	".mp3": func(r io.ReadSeeker) (Decoder, error) { return NewMP3Decoder(r) },
+	".ogg": func(r io.ReadSeeker) (Decoder, error) { return NewOggDecoder(r) },
+}
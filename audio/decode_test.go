@@ -0,0 +1,50 @@
+package audio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenFile(t *testing.T) {
+	tests := []struct {
+		path     string
+		format   Format
+		duration time.Duration
+		samples  int
+	}{
+		{"testdata/speech.mp3", Format{SampleRate: 22050, Channels: 2}, 23040 * time.Second / 22050, 46080},
+		{"testdata/tone.ogg", Format{SampleRate: 44100, Channels: 1}, time.Second, 44100},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			f, err := OpenFile(test.path)
+			require.NoError(t, err)
+			defer f.Close()
+			assert.Equal(t, test.format, f.Format())
+			assert.Equal(t, test.duration, f.Duration())
+			samples := readAll(t, f)
+			assert.Len(t, samples, test.samples)
+			assert.NotEqual(t, make([]int16, len(samples)), samples)
+		})
+	}
+}
+
+func TestProbe(t *testing.T) {
+	info, err := Probe("testdata/speech.mp3")
+	require.NoError(t, err)
+	assert.Equal(t, Info{SampleRate: 22050, Channels: 1, Duration: 23040 * time.Second / 22050}, info)
+
+	info, err = Probe("testdata/tone.ogg")
+	require.NoError(t, err)
+	assert.Equal(t, Info{SampleRate: 44100, Channels: 1, Duration: time.Second}, info)
+}
+
+func TestOpenFileChecksExtension(t *testing.T) {
+	assert.True(t, CanDecode("bellbird-6.MP3"))
+	assert.False(t, CanDecode("bellbird-6.flac"))
+	_, err := OpenFile("bellbird-6.flac")
+	assert.EqualError(t, err, "can't decode '.flac' files")
+}
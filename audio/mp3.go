@@ -0,0 +1,116 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audio
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// MP3Decoder reads the samples from an MP3 file. They are always decoded to
+// two channels, even for a mono file.
+type MP3Decoder struct {
+	decoder *mp3.Decoder
+	buf     []byte
+}
+
+// NewMP3Decoder reads the first frame of an MP3 file. The duration is only
+// known if r is an io.Seeker.
+func NewMP3Decoder(r io.Reader) (*MP3Decoder, error) {
+	decoder, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	return &MP3Decoder{decoder: decoder}, nil
+}
+
+// Format returns the format of the decoded samples.
+func (d *MP3Decoder) Format() Format {
+	return Format{SampleRate: d.decoder.SampleRate(), Channels: 2}
+}
+
+// Duration returns how long the audio plays for, or 0 if it isn't known.
+func (d *MP3Decoder) Duration() time.Duration {
+	length := d.decoder.Length()
+	if length < 0 {
+		return 0
+	}
+	// Each frame is two 16 bit samples.
+	return time.Duration(length/4) * time.Second / time.Duration(d.decoder.SampleRate())
+}
+
+// ReadSamples decodes samples into samples, returning how many were read.
+// It returns io.EOF once all the samples have been read.
+func (d *MP3Decoder) ReadSamples(samples []int16) (int, error) {
+	if cap(d.buf) < len(samples)*2 {
+		d.buf = make([]byte, len(samples)*2)
+	}
+	buf := d.buf[:len(samples)*2]
+	n, err := io.ReadFull(d.decoder, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	count := n / 2
+	for i := 0; i < count; i++ {
+		samples[i] = int16(uint16(buf[i*2]) | uint16(buf[i*2+1])<<8)
+	}
+	if count == 0 && err == nil {
+		err = io.EOF
+	}
+	return count, err
+}
+
+// mp3Channels reads the number of channels from the first frame header of an
+// MP3 file, skipping any ID3v2 tag before it.
+func mp3Channels(r io.Reader) (int, error) {
+	// The first frame is normally near the start, unless there is a large tag.
+	b, err := ioutil.ReadAll(io.LimitReader(r, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	i := 0
+	if len(b) >= 10 && string(b[0:3]) == "ID3" {
+		i = 10 + (int(b[6])<<21 | int(b[7])<<14 | int(b[8])<<7 | int(b[9]))
+		if b[5]&0x10 != 0 {
+			// There is a footer as well.
+			i += 10
+		}
+	}
+	for ; i+4 <= len(b); i++ {
+		if b[i] != 0xFF || b[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		layer := (b[i+1] >> 1) & 3
+		bitrate := b[i+2] >> 4
+		sampleRate := (b[i+2] >> 2) & 3
+		if layer == 0 || bitrate == 15 || sampleRate == 3 {
+			continue
+		}
+		// Channel mode 3 is mono, the others are kinds of stereo.
+		if b[i+3]>>6 == 3 {
+			return 1, nil
+		}
+		return 2, nil
+	}
+	return 0, errors.New("no MP3 frame header found")
+}
@@ -0,0 +1,75 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audio
+
+import (
+	"io"
+	"time"
+
+	"github.com/jfreymuth/oggvorbis"
+)
+
+// OggDecoder reads the samples from an Ogg Vorbis file.
+type OggDecoder struct {
+	reader *oggvorbis.Reader
+	buf    []float32
+}
+
+// NewOggDecoder reads the headers of an Ogg Vorbis file. The duration is only
+// known if r is an io.Seeker.
+func NewOggDecoder(r io.Reader) (*OggDecoder, error) {
+	reader, err := oggvorbis.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &OggDecoder{reader: reader}, nil
+}
+
+// Format returns the format of the decoded samples.
+func (d *OggDecoder) Format() Format {
+	return Format{SampleRate: d.reader.SampleRate(), Channels: d.reader.Channels()}
+}
+
+// Duration returns how long the audio plays for, or 0 if it isn't known.
+func (d *OggDecoder) Duration() time.Duration {
+	return time.Duration(d.reader.Length()) * time.Second / time.Duration(d.reader.SampleRate())
+}
+
+// ReadSamples decodes samples into samples, returning how many were read.
+// It returns io.EOF once all the samples have been read.
+func (d *OggDecoder) ReadSamples(samples []int16) (int, error) {
+	if cap(d.buf) < len(samples) {
+		d.buf = make([]float32, len(samples))
+	}
+	buf := d.buf[:len(samples)]
+	count := 0
+	var err error
+	for count < len(buf) && err == nil {
+		var n int
+		n, err = d.reader.Read(buf[count:])
+		count += n
+	}
+	for i := 0; i < count; i++ {
+		samples[i] = floatToSample(buf[i])
+	}
+	if err == io.EOF && count > 0 {
+		err = nil
+	}
+	return count, err
+}
@@ -0,0 +1,124 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// Output is somewhere PCM audio can be played.
+type Output interface {
+	// Open starts playing a sound in the format. Its samples are written to the
+	// returned writer as 16 bit little endian PCM, which is closed once they
+	// have all been written. Close returns once the sound has finished playing.
+	// Cancelling the context stops the sound.
+	Open(ctx context.Context, format Format) (io.WriteCloser, error)
+}
+
+// AplayOutput plays sounds on an ALSA device with aplay.
+type AplayOutput struct {
+	// Device is the ALSA device name, such as "default" or "hw:0".
+	Device string
+}
+
+func (o AplayOutput) Open(ctx context.Context, format Format) (io.WriteCloser, error) {
+	cmd := exec.CommandContext(ctx, "aplay", "-q",
+		"-D", o.Device,
+		"-t", "raw",
+		"-f", "S16_LE",
+		"-c", fmt.Sprint(format.Channels),
+		"-r", fmt.Sprint(format.SampleRate),
+	)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start aplay: %v", err)
+	}
+	return &aplayWriter{WriteCloser: stdin, cmd: cmd}, nil
+}
+
+type aplayWriter struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+// Close waits for aplay to finish playing.
+func (w *aplayWriter) Close() error {
+	closeErr := w.WriteCloser.Close()
+	if err := w.cmd.Wait(); err != nil {
+		return fmt.Errorf("aplay failed: %v", err)
+	}
+	return closeErr
+}
+
+// FileOutput writes each sound to a WAV file in a directory instead of playing
+// it, for testing on machines without a sound card. The files are named
+// sound-1.wav, sound-2.wav, and so on.
+type FileOutput struct {
+	Dir string
+
+	mu    sync.Mutex
+	count int
+}
+
+func (o *FileOutput) Open(ctx context.Context, format Format) (io.WriteCloser, error) {
+	o.mu.Lock()
+	o.count++
+	name := fmt.Sprintf("sound-%d.wav", o.count)
+	o.mu.Unlock()
+
+	f, err := os.Create(filepath.Join(o.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+	// The sizes in the header are filled in on Close.
+	if _, err := f.Write(wavHeader(format, 0)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &wavFileWriter{file: f, format: format}, nil
+}
+
+type wavFileWriter struct {
+	file   *os.File
+	format Format
+	size   uint32
+}
+
+func (w *wavFileWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.size += uint32(n)
+	return n, err
+}
+
+func (w *wavFileWriter) Close() error {
+	if _, err := w.file.WriteAt(wavHeader(w.format, w.size), 0); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
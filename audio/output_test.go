@@ -0,0 +1,36 @@
+package audio
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audio-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	output := &FileOutput{Dir: dir}
+	format := Format{SampleRate: 44100, Channels: 1}
+
+	for i := 0; i < 2; i++ {
+		w, err := output.Open(context.Background(), format)
+		require.NoError(t, err)
+		_, err = w.Write(SamplesToBytes([]int16{1, 2}))
+		require.NoError(t, err)
+		_, err = w.Write(SamplesToBytes([]int16{3}))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+
+	f, err := OpenFile(filepath.Join(dir, "sound-2.wav"))
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, format, f.Format())
+	assert.Equal(t, []int16{1, 2, 3}, readAll(t, f))
+}
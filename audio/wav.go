@@ -0,0 +1,232 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package audio decodes audio files to PCM and writes PCM to audio outputs,
+// so sounds can be played without an external audio player.
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+// WAV format codes
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// ErrNotWAV is returned when a file isn't a RIFF WAVE file.
+var ErrNotWAV = errors.New("not a WAV file")
+
+// Format describes PCM audio. Samples are always 16 bit signed integers,
+// interleaved by channel.
+type Format struct {
+	SampleRate int
+	Channels   int
+}
+
+// WAVDecoder reads the samples from a WAV file as 16 bit PCM. 8, 16, 24 and
+// 32 bit integer and 32 bit float files are supported.
+type WAVDecoder struct {
+	format        Format
+	bitsPerSample int
+	float         bool
+	frames        int64
+	data          io.Reader
+	buf           []byte
+}
+
+// NewWAVDecoder reads the header of a WAV file, leaving r at the start of the samples.
+func NewWAVDecoder(r io.Reader) (*WAVDecoder, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, ErrNotWAV
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, ErrNotWAV
+	}
+
+	d := &WAVDecoder{}
+	haveFormat := false
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, fmt.Errorf("WAV file has no data chunk: %v", err)
+		}
+		id := string(header[0:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+		switch id {
+		case "fmt ":
+			if err := d.readFormat(io.LimitReader(r, size), size); err != nil {
+				return nil, err
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return nil, errors.New("WAV data chunk comes before the fmt chunk")
+			}
+			frameSize := int64(d.format.Channels * d.bitsPerSample / 8)
+			d.frames = size / frameSize
+			d.data = io.LimitReader(r, d.frames*frameSize)
+			return d, nil
+		default:
+			if _, err := io.CopyN(ioutil.Discard, r, size); err != nil {
+				return nil, fmt.Errorf("could not skip '%s' chunk: %v", id, err)
+			}
+		}
+		// Chunks are padded to an even size.
+		if size%2 == 1 {
+			if _, err := io.CopyN(ioutil.Discard, r, 1); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (d *WAVDecoder) readFormat(r io.Reader, size int64) error {
+	if size < 16 {
+		return errors.New("WAV fmt chunk is too short")
+	}
+	fmtChunk := make([]byte, size)
+	if _, err := io.ReadFull(r, fmtChunk); err != nil {
+		return err
+	}
+	formatCode := binary.LittleEndian.Uint16(fmtChunk[0:2])
+	d.format.Channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+	d.format.SampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+	d.bitsPerSample = int(binary.LittleEndian.Uint16(fmtChunk[14:16]))
+	if formatCode == wavFormatExtensible {
+		if size < 26 {
+			return errors.New("WAV extensible fmt chunk is too short")
+		}
+		// The sub format GUID starts with the format code.
+		formatCode = binary.LittleEndian.Uint16(fmtChunk[24:26])
+	}
+
+	switch {
+	case formatCode == wavFormatPCM && (d.bitsPerSample == 8 || d.bitsPerSample == 16 || d.bitsPerSample == 24 || d.bitsPerSample == 32):
+	case formatCode == wavFormatFloat && d.bitsPerSample == 32:
+		d.float = true
+	default:
+		return fmt.Errorf("unsupported WAV format %d with %d bits per sample", formatCode, d.bitsPerSample)
+	}
+	if d.format.Channels < 1 || d.format.SampleRate < 1 {
+		return fmt.Errorf("invalid WAV format: %d channels at %dHz", d.format.Channels, d.format.SampleRate)
+	}
+	return nil
+}
+
+// Format returns the format of the decoded samples.
+func (d *WAVDecoder) Format() Format {
+	return d.format
+}
+
+// Duration returns how long the audio plays for.
+func (d *WAVDecoder) Duration() time.Duration {
+	return time.Duration(d.frames) * time.Second / time.Duration(d.format.SampleRate)
+}
+
+// ReadSamples decodes samples into samples, returning how many were read.
+// It returns io.EOF once all the samples have been read.
+func (d *WAVDecoder) ReadSamples(samples []int16) (int, error) {
+	sampleSize := d.bitsPerSample / 8
+	if cap(d.buf) < len(samples)*sampleSize {
+		d.buf = make([]byte, len(samples)*sampleSize)
+	}
+	buf := d.buf[:len(samples)*sampleSize]
+	n, err := io.ReadFull(d.data, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	count := n / sampleSize
+	for i := 0; i < count; i++ {
+		samples[i] = d.decodeSample(buf[i*sampleSize : (i+1)*sampleSize])
+	}
+	if count == 0 && err == nil {
+		err = io.EOF
+	}
+	return count, err
+}
+
+func (d *WAVDecoder) decodeSample(b []byte) int16 {
+	switch {
+	case d.float:
+		return floatToSample(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case d.bitsPerSample == 8:
+		// 8 bit samples are unsigned.
+		return int16(int(b[0])-128) << 8
+	default:
+		// Keep the most significant 16 bits.
+		n := len(b)
+		return int16(binary.LittleEndian.Uint16(b[n-2 : n]))
+	}
+}
+
+func floatToSample(f float32) int16 {
+	if f >= 1 {
+		return math.MaxInt16
+	}
+	if f <= -1 {
+		return -math.MaxInt16
+	}
+	return int16(f * math.MaxInt16)
+}
+
+// wavHeader makes the header of a 16 bit PCM WAV file with the given amount of sample data.
+func wavHeader(format Format, dataSize uint32) []byte {
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], 36+dataSize)
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], wavFormatPCM)
+	binary.LittleEndian.PutUint16(header[22:24], uint16(format.Channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(format.SampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(format.SampleRate*format.Channels*2))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(format.Channels*2))
+	binary.LittleEndian.PutUint16(header[34:36], 16)
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], dataSize)
+	return header
+}
+
+// WriteWAV writes samples as a 16 bit PCM WAV file.
+func WriteWAV(w io.Writer, format Format, samples []int16) error {
+	if _, err := w.Write(wavHeader(format, uint32(len(samples)*2))); err != nil {
+		return err
+	}
+	_, err := w.Write(SamplesToBytes(samples))
+	return err
+}
+
+// SamplesToBytes converts samples to 16 bit little endian PCM.
+func SamplesToBytes(samples []int16) []byte {
+	b := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(b[i*2:], uint16(s))
+	}
+	return b
+}
@@ -0,0 +1,97 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, d Decoder) []int16 {
+	var all []int16
+	buf := make([]int16, 3)
+	for {
+		n, err := d.ReadSamples(buf)
+		all = append(all, buf[:n]...)
+		if err == io.EOF {
+			return all
+		}
+		require.NoError(t, err)
+	}
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	format := Format{SampleRate: 8000, Channels: 2}
+	samples := []int16{0, 1, -1, 32767, -32768, 1000, 2000, -2000}
+	var buf bytes.Buffer
+	require.NoError(t, WriteWAV(&buf, format, samples))
+
+	d, err := NewWAVDecoder(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, format, d.Format())
+	assert.Equal(t, 500*time.Microsecond, d.Duration())
+	assert.Equal(t, samples, readAll(t, d))
+}
+
+// wavFile makes a WAV file by hand, with a chunk before the fmt chunk to skip.
+func wavFile(formatCode, bitsPerSample int, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(0))
+	b.WriteString("WAVE")
+	b.WriteString("LIST")
+	binary.Write(&b, binary.LittleEndian, uint32(3))
+	b.Write([]byte{1, 2, 3, 0})
+	b.WriteString("fmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(formatCode))
+	binary.Write(&b, binary.LittleEndian, uint16(1))
+	binary.Write(&b, binary.LittleEndian, uint32(100))
+	binary.Write(&b, binary.LittleEndian, uint32(100*bitsPerSample/8))
+	binary.Write(&b, binary.LittleEndian, uint16(bitsPerSample/8))
+	binary.Write(&b, binary.LittleEndian, uint16(bitsPerSample))
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func TestWAVSampleFormats(t *testing.T) {
+	float := func(fs ...float32) []byte {
+		var b bytes.Buffer
+		binary.Write(&b, binary.LittleEndian, fs)
+		return b.Bytes()
+	}
+	tests := []struct {
+		name          string
+		formatCode    int
+		bitsPerSample int
+		data          []byte
+		samples       []int16
+	}{
+		{"8 bit", wavFormatPCM, 8, []byte{128, 255, 0}, []int16{0, 127 << 8, -32768}},
+		{"24 bit", wavFormatPCM, 24, []byte{0xff, 0x34, 0x12, 0, 0, 0x80}, []int16{0x1234, -32768}},
+		{"32 bit", wavFormatPCM, 32, []byte{0, 0, 0x34, 0x12}, []int16{0x1234}},
+		{"float", wavFormatFloat, 32, float(0, 0.5, -2), []int16{0, 16383, -32767}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := NewWAVDecoder(bytes.NewReader(wavFile(test.formatCode, test.bitsPerSample, test.data)))
+			require.NoError(t, err)
+			assert.Equal(t, Format{SampleRate: 100, Channels: 1}, d.Format())
+			assert.Equal(t, test.samples, readAll(t, d))
+		})
+	}
+}
+
+func TestNotWAV(t *testing.T) {
+	_, err := NewWAVDecoder(bytes.NewReader([]byte("ID3 this is an mp3 file")))
+	assert.Equal(t, ErrNotWAV, err)
+
+	_, err = NewWAVDecoder(bytes.NewReader(wavFile(2, 4, nil)))
+	assert.Error(t, err)
+}
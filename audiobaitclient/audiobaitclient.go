@@ -19,41 +19,327 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package audiobaitclient
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/TheCacophonyProject/event-reporter/eventclient"
 	"github.com/godbus/dbus"
 )
 
+const (
+	dbusDest = "org.cacophony.Audiobait"
+	dbusPath = "/org/cacophony/Audiobait"
+)
+
 // Can be mocked for testing
 var dbusCall = func(method string, params ...interface{}) ([]interface{}, error) {
 	conn, err := dbus.SystemBus()
 	if err != nil {
 		return nil, err
 	}
-	obj := conn.Object("org.cacophony.Audiobait", "/org/cacophony/Audiobait")
-	call := obj.Call(method, 0, params...)
-	return call.Body, call.Err
+	return New(conn).dbusCall(context.Background(), method, params...)
+}
+
+// Can be mocked for testing
+var dbusSignals = func(members ...string) (<-chan *dbus.Signal, func(), error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, nil, err
+	}
+	return New(conn).dbusSignals(members...)
+}
+
+// packageClient is the client used by the package level functions. It goes
+// through dbusCall and dbusSignals, which connect to the system bus.
+var packageClient = &Client{
+	call: func(ctx context.Context, method string, params ...interface{}) ([]interface{}, error) {
+		return dbusCall(method, params...)
+	},
+	signals: func(members ...string) (<-chan *dbus.Signal, func(), error) {
+		return dbusSignals(members...)
+	},
 }
 
 var ErrorParsingOutput = errors.New("error with parsing dbus output")
 
+// ErrTimeout is returned when a request doesn't finish in time.
+var ErrTimeout = errors.New("timed out waiting for request")
+
+// Client makes requests to audiobait over a D-Bus connection. Every method takes
+// a context, and gives up waiting for audiobait with the context's error once it
+// is done. Methods such as PlayFromId wait for the sound to be played, so their
+// deadline needs to allow for that.
+type Client struct {
+	conn *dbus.Conn
+	// owned is true if the connection was opened by Dial, and so is closed by Close.
+	owned bool
+
+	call    func(ctx context.Context, method string, params ...interface{}) ([]interface{}, error)
+	signals func(members ...string) (<-chan *dbus.Signal, func(), error)
+}
+
+// New creates a client that uses the connection, such as the one from dbus.SystemBus.
+func New(conn *dbus.Conn) *Client {
+	c := &Client{conn: conn}
+	c.call = c.dbusCall
+	c.signals = c.dbusSignals
+	return c
+}
+
+// Dial creates a client with its own connection to the bus at the address,
+// such as a private session bus for testing. Close the client when finished with it.
+func Dial(address string) (*Client, error) {
+	conn, err := dbus.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := New(conn)
+	c.owned = true
+	return c, nil
+}
+
+// Close closes the connection if it was opened by Dial.
+func (c *Client) Close() error {
+	if c.owned {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (c *Client) dbusCall(ctx context.Context, method string, params ...interface{}) ([]interface{}, error) {
+	call := c.conn.Object(dbusDest, dbusPath).Go(method, 0, make(chan *dbus.Call, 1), params...)
+	select {
+	case <-call.Done:
+		return call.Body, call.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dbusSignals listens for signals from audiobait. The function returned stops listening.
+func (c *Client) dbusSignals(members ...string) (<-chan *dbus.Signal, func(), error) {
+	var rules []string
+	stop := func() {
+		for _, rule := range rules {
+			c.conn.BusObject().Call("org.freedesktop.DBus.RemoveMatch", 0, rule)
+		}
+	}
+	for _, member := range members {
+		rule := fmt.Sprintf("type='signal',interface='%s',member='%s'", dbusDest, member)
+		if call := c.conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule); call.Err != nil {
+			stop()
+			return nil, nil, call.Err
+		}
+		rules = append(rules, rule)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	c.conn.Signal(signals)
+	return signals, func() {
+		c.conn.RemoveSignal(signals)
+		stop()
+	}, nil
+}
+
+// invoke calls a method on audiobait, turning the errors it returns into an Error.
+func (c *Client) invoke(ctx context.Context, method string, params ...interface{}) ([]interface{}, error) {
+	data, err := c.call(ctx, method, params...)
+	return data, fromDBusError(err)
+}
+
 // PlayFromId lets you make a request to audiobait to play an audio file.
 // audioFileId: ID of the audio file. Audio files available and there IDs can be found using audiofilelibrary.
 // volume: Volume to play the sound at from 1 to 10. Values over 10 can be used but the quality might decrease.
-// priority: //TODO
+// priority: The sound isn't played if a sound with a higher priority is playing, and waits for a sound with
+//           the same priority to finish. A sound with a lower priority is stopped, or waited for if
+//           audiobait isn't set to preempt sounds.
 // event: Event that will get logged when played. The audioFileID, volume, priority, and time will automatically get added to the event.
 //        If left null no event will be logged.
+// A sound that isn't played because it is muted returns ErrMuted. Use PlayFromIdWithReason to find
+// out why any other sound wasn't played.
 func PlayFromId(audioFileId, volume, priority int, event *eventclient.Event) (played bool, err error) {
-	var eventRaw []byte
-	if event != nil {
-		eventRaw, err = json.Marshal(event)
-		if err != nil {
-			return false, err
+	return packageClient.PlayFromId(context.Background(), audioFileId, volume, priority, event)
+}
+
+// PlayFromId asks audiobait to play an audio file, see the PlayFromId function.
+func (c *Client) PlayFromId(ctx context.Context, audioFileId, volume, priority int, event *eventclient.Event) (played bool, err error) {
+	eventRaw, err := marshalEvent(event)
+	if err != nil {
+		return false, err
+	}
+	data, err := c.invoke(ctx, "PlayFromId", audioFileId, volume, priority, eventRaw)
+	if err != nil {
+		return false, err
+	}
+	if len(data) != 1 {
+		return false, ErrorParsingOutput
+	}
+	played, ok := data[0].(bool)
+	if !ok {
+		return false, ErrorParsingOutput
+	}
+	return played, nil
+}
+
+// marshalEvent turns an event into the JSON sent to audiobait, which is empty for no event.
+func marshalEvent(event *eventclient.Event) (string, error) {
+	if event == nil {
+		return "", nil
+	}
+	eventRaw, err := json.Marshal(event)
+	return string(eventRaw), err
+}
+
+// PlayFromIdWithReason is PlayFromId but also returns the reason if the sound wasn't played.
+func PlayFromIdWithReason(audioFileId, volume, priority int, event *eventclient.Event) (played bool, reason string, err error) {
+	return packageClient.PlayFromIdWithReason(context.Background(), audioFileId, volume, priority, event)
+}
+
+// PlayFromIdWithReason is PlayFromId but also returns the reason if the sound wasn't played.
+func (c *Client) PlayFromIdWithReason(ctx context.Context, audioFileId, volume, priority int, event *eventclient.Event) (played bool, reason string, err error) {
+	eventRaw, err := marshalEvent(event)
+	if err != nil {
+		return false, "", err
+	}
+	data, err := c.invoke(ctx, "PlayFromIdWithReason", audioFileId, volume, priority, eventRaw)
+	if err != nil {
+		return false, "", err
+	}
+	if len(data) != 2 {
+		return false, "", ErrorParsingOutput
+	}
+	played, ok := data[0].(bool)
+	if !ok {
+		return false, "", ErrorParsingOutput
+	}
+	reason, ok = data[1].(string)
+	if !ok {
+		return false, "", ErrorParsingOutput
+	}
+	return played, reason, nil
+}
+
+// PlayFromIdAsync asks audiobait to play an audio file without waiting for it to be played.
+// The arguments are the same as for PlayFromId. The returned request ID can be given to
+// WaitForRequest to find out if the sound was played.
+func PlayFromIdAsync(audioFileId, volume, priority int, event *eventclient.Event) (requestID int, err error) {
+	return packageClient.PlayFromIdAsync(context.Background(), audioFileId, volume, priority, event)
+}
+
+// PlayFromIdAsync asks audiobait to play an audio file without waiting for it to be played.
+func (c *Client) PlayFromIdAsync(ctx context.Context, audioFileId, volume, priority int, event *eventclient.Event) (requestID int, err error) {
+	eventRaw, err := marshalEvent(event)
+	if err != nil {
+		return 0, err
+	}
+	data, err := c.invoke(ctx, "PlayFromIdAsync", audioFileId, volume, priority, eventRaw)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 1 {
+		return 0, ErrorParsingOutput
+	}
+	id, ok := data[0].(int32)
+	if !ok {
+		return 0, ErrorParsingOutput
+	}
+	return int(id), nil
+}
+
+// WaitForRequest waits for a request from PlayFromIdAsync to finish, returning whether the
+// sound was played and if not the reason. ErrTimeout is returned if it doesn't finish in time.
+func WaitForRequest(requestID int, timeout time.Duration) (played bool, reason string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	played, reason, err = packageClient.WaitForRequest(ctx, requestID)
+	if err == context.DeadlineExceeded {
+		return false, "", ErrTimeout
+	}
+	return played, reason, err
+}
+
+// WaitForRequest waits for a request from PlayFromIdAsync to finish, returning whether the
+// sound was played and if not the reason.
+func (c *Client) WaitForRequest(ctx context.Context, requestID int) (played bool, reason string, err error) {
+	// Listen for the request finishing before checking if it already has, so it can't be missed.
+	signals, stop, err := c.signals("PlayFinished")
+	if err != nil {
+		return false, "", err
+	}
+	defer stop()
+
+	data, err := c.invoke(ctx, "RequestResult", requestID)
+	if err != nil {
+		return false, "", err
+	}
+	if len(data) != 4 {
+		return false, "", ErrorParsingOutput
+	}
+	if done, ok := data[0].(bool); !ok {
+		return false, "", ErrorParsingOutput
+	} else if done {
+		return parseRequestResult(data[1:])
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			if sig.Name != dbusDest+".PlayFinished" || len(sig.Body) != 4 {
+				continue
+			}
+			if id, ok := sig.Body[0].(int32); ok && int(id) == requestID {
+				return parseRequestResult(sig.Body[1:])
+			}
+		case <-ctx.Done():
+			return false, "", ctx.Err()
 		}
 	}
-	data, err := dbusCall("PlayFromId", audioFileId, volume, priority, string(eventRaw))
+}
+
+// parseRequestResult parses the played, reason and error of a finished request.
+func parseRequestResult(data []interface{}) (played bool, reason string, err error) {
+	played, ok := data[0].(bool)
+	if !ok {
+		return false, "", ErrorParsingOutput
+	}
+	reason, ok = data[1].(string)
+	if !ok {
+		return false, "", ErrorParsingOutput
+	}
+	errMsg, ok := data[2].(string)
+	if !ok {
+		return false, "", ErrorParsingOutput
+	}
+	if errMsg != "" {
+		return played, reason, errors.New(errMsg)
+	}
+	return played, reason, nil
+}
+
+// PlayTrigger asks audiobait to play the sounds for a trigger. The trigger is looked up in the
+// combos of the current schedule, then in the triggers in audiobait's config.
+// trigger: Name of the trigger, as used for Trigger in the schedule's combos.
+// volume: Volume to play the sounds at from 1 to 10, or 0 to use the volumes from the schedule.
+// priority: Priority of the sounds, as for PlayFromId.
+// makeEvent: Log an event for each sound played.
+// Use PlayTriggerWithReason to find out why no sounds were played.
+func PlayTrigger(trigger string, volume, priority int, makeEvent bool) (played bool, err error) {
+	return packageClient.PlayTrigger(context.Background(), trigger, volume, priority, makeEvent)
+}
+
+// PlayTrigger asks audiobait to play the sounds for a trigger, see the PlayTrigger function.
+func (c *Client) PlayTrigger(ctx context.Context, trigger string, volume, priority int, makeEvent bool) (played bool, err error) {
+	data, err := c.invoke(ctx, "PlayTrigger", trigger, volume, priority, makeEvent)
 	if err != nil {
 		return false, err
 	}
@@ -67,7 +353,85 @@ func PlayFromId(audioFileId, volume, priority int, event *eventclient.Event) (pl
 	return played, nil
 }
 
+// PlayTriggerWithReason is PlayTrigger but also returns the reason if no sounds
+// were played. A combo in the schedule is only played on a play night, during its
+// window and once its Every has passed since the trigger last played it.
+func PlayTriggerWithReason(trigger string, volume, priority int, makeEvent bool) (played bool, reason string, err error) {
+	return packageClient.PlayTriggerWithReason(context.Background(), trigger, volume, priority, makeEvent)
+}
+
+// PlayTriggerWithReason is PlayTrigger but also returns the reason if no sounds were played.
+func (c *Client) PlayTriggerWithReason(ctx context.Context, trigger string, volume, priority int, makeEvent bool) (played bool, reason string, err error) {
+	data, err := c.invoke(ctx, "PlayTriggerWithReason", trigger, volume, priority, makeEvent)
+	if err != nil {
+		return false, "", err
+	}
+	if len(data) != 2 {
+		return false, "", ErrorParsingOutput
+	}
+	played, ok := data[0].(bool)
+	if !ok {
+		return false, "", ErrorParsingOutput
+	}
+	reason, ok = data[1].(string)
+	if !ok {
+		return false, "", ErrorParsingOutput
+	}
+	return played, reason, nil
+}
+
 func PlayTestSound(volume int) error {
-	_, err := dbusCall("PlayTestSound", volume)
+	return packageClient.PlayTestSound(context.Background(), volume)
+}
+
+func (c *Client) PlayTestSound(ctx context.Context, volume int) error {
+	_, err := c.invoke(ctx, "PlayTestSound", volume)
+	return err
+}
+
+// Stop cuts short the sound audiobait is playing. The sound's event records that it
+// was cut short and how long it played for. It returns false if no sound was playing.
+func Stop() (stopped bool, err error) {
+	return packageClient.Stop(context.Background())
+}
+
+// Stop cuts short the sound audiobait is playing, see the Stop function.
+func (c *Client) Stop(ctx context.Context) (stopped bool, err error) {
+	data, err := c.invoke(ctx, "Stop")
+	if err != nil {
+		return false, err
+	}
+	if len(data) != 1 {
+		return false, ErrorParsingOutput
+	}
+	stopped, ok := data[0].(bool)
+	if !ok {
+		return false, ErrorParsingOutput
+	}
+	return stopped, nil
+}
+
+// Mute stops audiobait playing sounds with a priority up to and including the given
+// priority, both from the schedule and from requests. The mute lasts for the duration,
+// rounded down to the second, or until Unmute is called if the duration is 0.
+// Sounds that aren't played because of the mute are recorded as events.
+func Mute(priority int, duration time.Duration) error {
+	return packageClient.Mute(context.Background(), priority, duration)
+}
+
+// Mute stops audiobait playing sounds up to the priority, see the Mute function.
+func (c *Client) Mute(ctx context.Context, priority int, duration time.Duration) error {
+	_, err := c.invoke(ctx, "Mute", priority, int(duration/time.Second))
+	return err
+}
+
+// Unmute removes the mute set by Mute.
+func Unmute() error {
+	return packageClient.Unmute(context.Background())
+}
+
+// Unmute removes the mute set by Mute.
+func (c *Client) Unmute(ctx context.Context) error {
+	_, err := c.invoke(ctx, "Unmute")
 	return err
 }
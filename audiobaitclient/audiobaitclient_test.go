@@ -3,8 +3,10 @@ package audiobaitclient
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/TheCacophonyProject/event-reporter/eventclient"
+	"github.com/godbus/dbus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -51,3 +53,198 @@ func TestPlayBadDBusReturns(t *testing.T) {
 	assert.False(t, success)
 	assert.Error(t, err)
 }
+
+func TestPlayTrigger(t *testing.T) {
+	var method string
+	var params []interface{}
+	dbusCall = func(m string, p ...interface{}) ([]interface{}, error) {
+		method, params = m, p
+		return []interface{}{true}, nil
+	}
+	played, err := PlayTrigger("Tracking", 0, 2, true)
+	assert.True(t, played)
+	assert.NoError(t, err)
+	assert.Equal(t, "PlayTrigger", method)
+	assert.Equal(t, []interface{}{"Tracking", 0, 2, true}, params)
+
+	dbusCall = mockDBusCall([]interface{}{"yes"}, nil)
+	played, err = PlayTrigger("Tracking", 0, 2, true)
+	assert.False(t, played)
+	assert.Equal(t, ErrorParsingOutput, err)
+}
+
+func TestPlayTriggerWithReason(t *testing.T) {
+	dbusCall = mockDBusCall([]interface{}{false, "tonight is a control night"}, nil)
+	played, reason, err := PlayTriggerWithReason("Tracking", 0, 2, true)
+	assert.False(t, played)
+	assert.Equal(t, "tonight is a control night", reason)
+	assert.NoError(t, err)
+
+	dbusCall = mockDBusCall([]interface{}{false}, nil)
+	_, _, err = PlayTriggerWithReason("Tracking", 0, 2, true)
+	assert.Equal(t, ErrorParsingOutput, err)
+}
+
+func TestPlayFromIdWithReason(t *testing.T) {
+	dbusCall = mockDBusCall([]interface{}{false, "a higher priority sound is playing"}, nil)
+	played, reason, err := PlayFromIdWithReason(1, 2, 3, nil)
+	assert.False(t, played)
+	assert.Equal(t, "a higher priority sound is playing", reason)
+	assert.NoError(t, err)
+
+	dbusCall = mockDBusCall([]interface{}{true}, nil) // Returning not enough
+	played, _, err = PlayFromIdWithReason(1, 2, 3, nil)
+	assert.False(t, played)
+	assert.Equal(t, ErrorParsingOutput, err)
+}
+
+const playFinished = "org.cacophony.Audiobait.PlayFinished"
+
+func mockDBusSignals(signals chan *dbus.Signal) {
+	dbusSignals = func(...string) (<-chan *dbus.Signal, func(), error) {
+		return signals, func() {}, nil
+	}
+}
+
+func TestPlayFromIdAsync(t *testing.T) {
+	dbusCall = mockDBusCall([]interface{}{int32(7)}, nil)
+	id, err := PlayFromIdAsync(1, 2, 3, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, id)
+
+	dbusCall = mockDBusCall([]interface{}{"7"}, nil) // Returning wrong type
+	_, err = PlayFromIdAsync(1, 2, 3, nil)
+	assert.Equal(t, ErrorParsingOutput, err)
+}
+
+func TestWaitForFinishedRequest(t *testing.T) {
+	mockDBusSignals(make(chan *dbus.Signal))
+	dbusCall = mockDBusCall([]interface{}{true, false, "a higher priority sound is playing", ""}, nil)
+	played, reason, err := WaitForRequest(7, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, played)
+	assert.Equal(t, "a higher priority sound is playing", reason)
+}
+
+func TestWaitForRequestSignal(t *testing.T) {
+	signals := make(chan *dbus.Signal, 2)
+	signals <- &dbus.Signal{Name: playFinished, Body: []interface{}{int32(6), false, "", "other request"}}
+	signals <- &dbus.Signal{Name: playFinished, Body: []interface{}{int32(7), true, "", ""}}
+	mockDBusSignals(signals)
+	dbusCall = mockDBusCall([]interface{}{false, false, "", ""}, nil)
+	played, _, err := WaitForRequest(7, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, played)
+}
+
+func TestWaitForRequestError(t *testing.T) {
+	signals := make(chan *dbus.Signal, 1)
+	signals <- &dbus.Signal{Name: playFinished, Body: []interface{}{int32(7), false, "", "file not found"}}
+	mockDBusSignals(signals)
+	dbusCall = mockDBusCall([]interface{}{false, false, "", ""}, nil)
+	played, _, err := WaitForRequest(7, time.Second)
+	assert.EqualError(t, err, "file not found")
+	assert.False(t, played)
+}
+
+func TestWaitForRequestTimeout(t *testing.T) {
+	mockDBusSignals(make(chan *dbus.Signal))
+	dbusCall = mockDBusCall([]interface{}{false, false, "", ""}, nil)
+	_, _, err := WaitForRequest(7, time.Millisecond)
+	assert.Equal(t, ErrTimeout, err)
+}
+
+func TestMute(t *testing.T) {
+	var params []interface{}
+	dbusCall = func(m string, p ...interface{}) ([]interface{}, error) {
+		assert.Equal(t, "Mute", m)
+		params = p
+		return nil, nil
+	}
+	assert.NoError(t, Mute(2, 90*time.Second))
+	assert.Equal(t, []interface{}{2, 90}, params)
+}
+
+func TestGetStatus(t *testing.T) {
+	dbusCall = mockDBusCall([]interface{}{`{"schedule": "lure", "tonight": "play", "librarySize": 3}`}, nil)
+	status, err := GetStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, &Status{Schedule: "lure", Tonight: PlayNight, LibrarySize: 3}, status)
+
+	dbusCall = mockDBusCall([]interface{}{true}, nil) // Returning wrong type
+	_, err = GetStatus()
+	assert.Equal(t, ErrorParsingOutput, err)
+}
+
+func TestSubscribePlayback(t *testing.T) {
+	signals := make(chan *dbus.Signal, 3)
+	mockDBusSignals(signals)
+	playbacks, stop, err := SubscribePlayback()
+	assert.NoError(t, err)
+
+	start := time.Unix(100, 0)
+	end := time.Unix(105, 0)
+	signals <- &dbus.Signal{Name: playFinished, Body: []interface{}{int32(6), false, "", ""}}
+	signals <- &dbus.Signal{
+		Name: "org.cacophony.Audiobait.PlaybackStarted",
+		Body: []interface{}{int32(1), int32(2), int32(3), SourceSchedule, start.UnixNano(), int64(8 * time.Second)},
+	}
+	signals <- &dbus.Signal{
+		Name: "org.cacophony.Audiobait.PlaybackFinished",
+		Body: []interface{}{int32(1), int32(2), int32(3), SourceSchedule, start.UnixNano(), int64(8 * time.Second),
+			end.UnixNano(), int64(5 * time.Second)},
+	}
+
+	started := <-playbacks
+	assert.False(t, started.Finished())
+	assert.Equal(t, Playback{FileID: 1, Volume: 2, Priority: 3, Source: SourceSchedule, Start: start, Duration: 8 * time.Second}, started)
+	finished := <-playbacks
+	assert.True(t, finished.Finished())
+	assert.Equal(t, end, finished.End)
+	assert.Equal(t, 8*time.Second, finished.Duration)
+	assert.Equal(t, 5*time.Second, finished.Elapsed)
+
+	stop()
+	stop()
+	_, open := <-playbacks
+	assert.False(t, open)
+}
+
+func TestStop(t *testing.T) {
+	dbusCall = mockDBusCall([]interface{}{true}, nil)
+	stopped, err := Stop()
+	assert.NoError(t, err)
+	assert.True(t, stopped)
+
+	dbusCall = mockDBusCall([]interface{}{}, nil) // Returning not enough
+	_, err = Stop()
+	assert.Equal(t, ErrorParsingOutput, err)
+}
+
+func TestErrorsFromAudiobait(t *testing.T) {
+	dbusCall = mockDBusCall(nil, dbus.Error{
+		Name: "org.cacophony.Audiobait.Error.FileNotFound",
+		Body: []interface{}{"could not find file with ID 3"},
+	})
+	_, err := PlayFromId(3, 2, 1, nil)
+	assert.True(t, errors.Is(err, ErrFileNotFound))
+	assert.False(t, errors.Is(err, ErrSoundCard))
+	assert.EqualError(t, err, "could not find file with ID 3")
+
+	dbusCall = mockDBusCall(nil, dbus.Error{Name: "org.cacophony.Audiobait.Error.SoundCard", Body: []interface{}{"aplay failed"}})
+	_, _, err = PlayFromIdWithReason(3, 2, 1, nil)
+	assert.True(t, errors.Is(err, ErrSoundCard))
+
+	dbusCall = mockDBusCall(nil, dbus.Error{Name: "org.cacophony.Audiobait.Error.Muted", Body: []interface{}{"muted"}})
+	_, err = PlayFromId(3, 2, 1, nil)
+	assert.True(t, errors.Is(err, ErrMuted))
+
+	other := dbus.Error{Name: "org.freedesktop.DBus.Error.ServiceUnknown"}
+	dbusCall = mockDBusCall(nil, other)
+	assert.Equal(t, other, PlayTestSound(5))
+}
+
+func TestErrorName(t *testing.T) {
+	assert.Equal(t, "org.cacophony.Audiobait.Error.UnknownTrigger", ErrorName(ErrUnknownTrigger))
+	assert.Equal(t, "org.cacophony.Audiobait.Error.Failed", ErrorName(errors.New("other")))
+}
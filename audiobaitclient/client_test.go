@@ -0,0 +1,133 @@
+package audiobaitclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSessionBus starts a private session bus, returning its address and a
+// function to stop it. The test is skipped if dbus-daemon isn't installed.
+func startSessionBus(t *testing.T) (string, func()) {
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+	cmd := exec.Command(path, "--session", "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatalf("could not read bus address: %v", err)
+	}
+	return strings.TrimSpace(address), func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+}
+
+// fakeAudiobait is exported on the test bus in place of audiobait.
+type fakeAudiobait struct {
+	release chan struct{}
+}
+
+func (f fakeAudiobait) PlayFromIdWithReason(fileId, volume, priority int, eventRaw string) (bool, string, *dbus.Error) {
+	if fileId != 1 {
+		return false, "", &dbus.Error{
+			Name: "org.cacophony.Audiobait.Error.FileNotFound",
+			Body: []interface{}{"could not find file"},
+		}
+	}
+	return true, "", nil
+}
+
+func (f fakeAudiobait) PlayTestSound(volume int) *dbus.Error {
+	<-f.release
+	return nil
+}
+
+// startFakeAudiobait exports a fakeAudiobait on the bus, returning the connection it uses.
+func startFakeAudiobait(t *testing.T, address string, fake fakeAudiobait) *dbus.Conn {
+	conn, err := dbus.Dial(address)
+	require.NoError(t, err)
+	require.NoError(t, conn.Auth(nil))
+	require.NoError(t, conn.Hello())
+	_, err = conn.RequestName(dbusDest, dbus.NameFlagDoNotQueue)
+	require.NoError(t, err)
+	require.NoError(t, conn.Export(fake, dbusPath, dbusDest))
+	return conn
+}
+
+func TestClientOnSessionBus(t *testing.T) {
+	address, stopBus := startSessionBus(t)
+	defer stopBus()
+	fake := fakeAudiobait{release: make(chan struct{})}
+	defer close(fake.release)
+	server := startFakeAudiobait(t, address, fake)
+	defer server.Close()
+
+	client, err := Dial(address)
+	require.NoError(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	played, reason, err := client.PlayFromIdWithReason(ctx, 1, 5, 1, nil)
+	assert.NoError(t, err)
+	assert.True(t, played)
+	assert.Equal(t, "", reason)
+
+	_, _, err = client.PlayFromIdWithReason(ctx, 2, 5, 1, nil)
+	assert.True(t, errors.Is(err, ErrFileNotFound))
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, client.PlayTestSound(timeoutCtx, 5))
+
+	playbacks, stop, err := client.SubscribePlayback(ctx)
+	require.NoError(t, err)
+	defer stop()
+	start := time.Unix(100, 0)
+	require.NoError(t, server.Emit(dbusPath, dbusDest+".PlaybackStarted", 1, 5, 1, SourceClient, start.UnixNano(), int64(time.Second)))
+	select {
+	case playback := <-playbacks:
+		assert.Equal(t, Playback{FileID: 1, Volume: 5, Priority: 1, Source: SourceClient, Start: start, Duration: time.Second}, playback)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no playback signal received")
+	}
+}
+
+func TestClientCallsTakeContext(t *testing.T) {
+	c := &Client{call: func(ctx context.Context, method string, params ...interface{}) ([]interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.Stop(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestSubscriptionStopsWithContext(t *testing.T) {
+	stopped := make(chan struct{})
+	c := &Client{signals: func(...string) (<-chan *dbus.Signal, func(), error) {
+		return make(chan *dbus.Signal), func() { close(stopped) }, nil
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	playbacks, stop, err := c.SubscribePlayback(ctx)
+	require.NoError(t, err)
+	cancel()
+	_, open := <-playbacks
+	assert.False(t, open)
+	<-stopped
+	stop()
+}
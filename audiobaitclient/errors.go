@@ -0,0 +1,120 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audiobaitclient
+
+import (
+	"errors"
+
+	"github.com/godbus/dbus"
+)
+
+// ErrorPrefix starts the name of every D-Bus error audiobait returns.
+const ErrorPrefix = dbusDest + ".Error."
+
+// Errors returned by audiobait. Use errors.Is to check for them.
+var (
+	// ErrFileNotFound (org.cacophony.Audiobait.Error.FileNotFound) is returned when
+	// there is no audio file with the ID.
+	ErrFileNotFound = errors.New("file not found")
+	// ErrSoundCard (org.cacophony.Audiobait.Error.SoundCard) is returned when the
+	// sound card fails to play a sound.
+	ErrSoundCard = errors.New("sound card failure")
+	// ErrMuted (org.cacophony.Audiobait.Error.Muted) is returned by PlayFromId when
+	// the sound isn't played because it is muted. PlayFromIdWithReason gives the
+	// reason "muted" instead.
+	ErrMuted = errors.New("muted")
+	// ErrBusy (org.cacophony.Audiobait.Error.Busy) is returned when the test sound
+	// isn't played because another sound is playing.
+	ErrBusy = errors.New("another sound is playing")
+	// ErrInvalidEvent (org.cacophony.Audiobait.Error.InvalidEvent) is returned when
+	// the event given to play with a sound isn't valid JSON.
+	ErrInvalidEvent = errors.New("invalid event")
+	// ErrUnknownTrigger (org.cacophony.Audiobait.Error.UnknownTrigger) is returned
+	// when there are no sounds for a trigger.
+	ErrUnknownTrigger = errors.New("unknown trigger")
+	// ErrUnknownRequest (org.cacophony.Audiobait.Error.UnknownRequest) is returned
+	// for a request ID that was never given out or has been forgotten.
+	ErrUnknownRequest = errors.New("unknown request")
+	// ErrInvalidArgument (org.cacophony.Audiobait.Error.InvalidArgument) is returned
+	// when an argument is out of range.
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrFailed (org.cacophony.Audiobait.Error.Failed) is returned for any other problem.
+	ErrFailed = errors.New("failed")
+)
+
+var errorNames = []struct {
+	name string
+	err  error
+}{
+	{"FileNotFound", ErrFileNotFound},
+	{"SoundCard", ErrSoundCard},
+	{"Muted", ErrMuted},
+	{"Busy", ErrBusy},
+	{"InvalidEvent", ErrInvalidEvent},
+	{"UnknownTrigger", ErrUnknownTrigger},
+	{"UnknownRequest", ErrUnknownRequest},
+	{"InvalidArgument", ErrInvalidArgument},
+	{"Failed", ErrFailed},
+}
+
+// ErrorName gives the D-Bus error name for an error, which is
+// org.cacophony.Audiobait.Error.Failed unless it is one of the errors above.
+func ErrorName(err error) string {
+	for _, e := range errorNames {
+		if errors.Is(err, e.err) {
+			return ErrorPrefix + e.name
+		}
+	}
+	return ErrorPrefix + "Failed"
+}
+
+// Error is an error returned by audiobait. errors.Is matches it with the error
+// for its name.
+type Error struct {
+	Name    string
+	Message string
+	err     error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// fromDBusError turns the D-Bus errors audiobait returns into an Error.
+// Other errors are returned as they are.
+func fromDBusError(err error) error {
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) {
+		return err
+	}
+	for _, e := range errorNames {
+		if dbusErr.Name == ErrorPrefix+e.name {
+			return &Error{
+				Name:    dbusErr.Name,
+				Message: dbusErr.Error(),
+				err:     e.err,
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audiobaitclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// Where a sound being played came from.
+const (
+	// SourceSchedule is a sound played by the schedule.
+	SourceSchedule = "schedule"
+	// SourceClient is a sound asked for over D-Bus, such as with PlayFromId.
+	SourceClient = "client"
+	// SourceTrigger is a sound played for a trigger, either by a combo in the
+	// schedule or with PlayTrigger.
+	SourceTrigger = "trigger"
+)
+
+// Playback is sent when a sound starts or finishes playing.
+type Playback struct {
+	// FileID is the ID of the audio file, or 0 for the test sound.
+	FileID   int
+	Volume   int
+	Priority int
+	// Source is SourceSchedule, SourceClient or SourceTrigger.
+	Source string
+	// Start is when the sound started playing.
+	Start time.Time
+	// End is when the sound finished playing. It is zero when the sound has just started.
+	End time.Time
+	// Duration is how long the sound plays for, or 0 if it isn't known.
+	Duration time.Duration
+	// Elapsed is how long the sound played for before it finished, which is less
+	// than Duration if it was cut short. It is zero when the sound has just started.
+	Elapsed time.Duration
+}
+
+// Finished returns true if the playback is for a sound that has finished.
+func (p Playback) Finished() bool {
+	return !p.End.IsZero()
+}
+
+// SubscribePlayback sends on the returned channel each time audiobait starts or
+// finishes playing a sound. The function returned stops the subscription and closes
+// the channel. Playbacks are dropped if they aren't read quickly enough to keep the
+// channel's buffer from filling up.
+func SubscribePlayback() (<-chan Playback, func(), error) {
+	return packageClient.SubscribePlayback(context.Background())
+}
+
+// SubscribePlayback sends on the returned channel each time audiobait starts or
+// finishes playing a sound, see the SubscribePlayback function. The subscription
+// also stops when the context is done.
+func (c *Client) SubscribePlayback(ctx context.Context) (<-chan Playback, func(), error) {
+	signals, stopSignals, err := c.signals("PlaybackStarted", "PlaybackFinished")
+	if err != nil {
+		return nil, nil, err
+	}
+	playbacks := make(chan Playback, 10)
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			stopSignals()
+			close(done)
+		})
+	}
+	go func() {
+		defer close(playbacks)
+		for {
+			select {
+			case sig := <-signals:
+				if playback, ok := parsePlayback(sig); ok {
+					select {
+					case playbacks <- playback:
+					default:
+					}
+				}
+			case <-ctx.Done():
+				stop()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	return playbacks, stop, nil
+}
+
+// parsePlayback reads a PlaybackStarted or PlaybackFinished signal.
+func parsePlayback(sig *dbus.Signal) (Playback, bool) {
+	var playback Playback
+	switch {
+	case sig.Name == dbusDest+".PlaybackStarted" && len(sig.Body) == 6:
+	case sig.Name == dbusDest+".PlaybackFinished" && len(sig.Body) == 8:
+		end, ok1 := sig.Body[6].(int64)
+		elapsed, ok2 := sig.Body[7].(int64)
+		if !(ok1 && ok2) {
+			return playback, false
+		}
+		playback.End = time.Unix(0, end)
+		playback.Elapsed = time.Duration(elapsed)
+	default:
+		return playback, false
+	}
+	fileID, ok1 := sig.Body[0].(int32)
+	volume, ok2 := sig.Body[1].(int32)
+	priority, ok3 := sig.Body[2].(int32)
+	source, ok4 := sig.Body[3].(string)
+	start, ok5 := sig.Body[4].(int64)
+	duration, ok6 := sig.Body[5].(int64)
+	if !(ok1 && ok2 && ok3 && ok4 && ok5 && ok6) {
+		return playback, false
+	}
+	playback.FileID = int(fileID)
+	playback.Volume = int(volume)
+	playback.Priority = int(priority)
+	playback.Source = source
+	playback.Start = time.Unix(0, start)
+	playback.Duration = time.Duration(duration)
+	return playback, true
+}
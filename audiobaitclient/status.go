@@ -0,0 +1,115 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audiobaitclient
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Values of Status.Tonight
+const (
+	PlayNight    = "play"
+	ControlNight = "control"
+)
+
+// Status describes what audiobait is doing.
+type Status struct {
+	// Schedule is the description of the schedule being played.
+	Schedule string `json:"schedule"`
+	// ScheduleHash is the SHA-256 of the schedule file being played, in hex.
+	ScheduleHash string `json:"scheduleHash"`
+	// Tonight is PlayNight or ControlNight, or empty if there is no schedule with combos.
+	Tonight string `json:"tonight"`
+	// Combo is the combo that is playing or will play next on a play night.
+	Combo *ComboStatus `json:"combo,omitempty"`
+	// Playing is the sound that is playing, if any.
+	Playing *PlayingStatus `json:"playing,omitempty"`
+	// Mute is the mute set by Mute, if any.
+	Mute *MuteStatus `json:"mute,omitempty"`
+	// LastDownload is the result of the last check for a new schedule.
+	LastDownload *DownloadStatus `json:"lastDownload,omitempty"`
+	// LibrarySize is how many audio files audiobait has.
+	LibrarySize int `json:"librarySize"`
+}
+
+type ComboStatus struct {
+	// Index is the position of the combo in the schedule.
+	Index int `json:"index"`
+	// Start is when the combo's window started or will start.
+	Start time.Time `json:"start"`
+	// Active is true if the combo's window has started.
+	Active bool `json:"active"`
+	// Trigger is the trigger the combo waits for, if any.
+	Trigger string `json:"trigger,omitempty"`
+}
+
+type PlayingStatus struct {
+	FileID   int       `json:"fileId"`
+	FileName string    `json:"fileName"`
+	Volume   int       `json:"volume"`
+	Priority int       `json:"priority"`
+	Started  time.Time `json:"started"`
+	// Duration is how long the sound plays for, or 0 if it isn't known.
+	Duration time.Duration `json:"duration"`
+	// Elapsed is how long the sound has been playing for.
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+type MuteStatus struct {
+	// Priority is the highest priority that is muted.
+	Priority int `json:"priority"`
+	// Until is when the mute ends. It is zero if it lasts until Unmute is called.
+	Until time.Time `json:"until"`
+}
+
+type DownloadStatus struct {
+	// Source is where the schedule was checked for.
+	Source string    `json:"source"`
+	Time   time.Time `json:"time"`
+	// Updated is true if a new schedule was saved.
+	Updated bool   `json:"updated"`
+	Error   string `json:"error,omitempty"`
+}
+
+// GetStatus asks audiobait what it is doing.
+func GetStatus() (*Status, error) {
+	return packageClient.GetStatus(context.Background())
+}
+
+// GetStatus asks audiobait what it is doing.
+func (c *Client) GetStatus(ctx context.Context) (*Status, error) {
+	data, err := c.invoke(ctx, "Status")
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 1 {
+		return nil, ErrorParsingOutput
+	}
+	raw, ok := data[0].(string)
+	if !ok {
+		return nil, ErrorParsingOutput
+	}
+	status := &Status{}
+	if err := json.Unmarshal([]byte(raw), status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
@@ -33,6 +33,10 @@ import (
 type AudioFileLibrary struct {
 	soundsDirectory string
 	FilesByID       map[int]string
+	// loudness is the measured loudness of the files, keyed by file name.
+	loudness map[string]float64
+	// index describes the files, keyed by ID.
+	index map[int]FileInfo
 }
 
 // Take a file name and extract an ID from it.
@@ -71,8 +75,8 @@ func OpenLibrary(soundsDirectory string) (*AudioFileLibrary, error) {
 	// Get IDs from the filenames.
 	for _, file := range files {
 		fileID, err := extractIDFromFileName(file.Name())
-		if file.Name() == playlist.ScheduleFilename {
-			// This is the schedule file, just ignore it here.
+		if file.IsDir() || !isAudioFileName(file.Name()) {
+			// This is the schedule or one of the library's own files, just ignore it here.
 			continue
 		}
 		if err == nil {
@@ -81,10 +85,22 @@ func OpenLibrary(soundsDirectory string) (*AudioFileLibrary, error) {
 			log.Println(err)
 		}
 	}
+	library.readLoudness()
+	library.readIndex()
 
 	return library, nil
 }
 
+// isAudioFileName returns false for the files in the audio directory that aren't
+// audio files.
+func isAudioFileName(name string) bool {
+	switch name {
+	case playlist.ScheduleFilename, LoudnessFilename, IndexFilename:
+		return false
+	}
+	return !strings.HasSuffix(name, ".tmp")
+}
+
 // GetFileNameOnDisk takes a fileID and returns it's name, which was previously read from disk.
 func (library *AudioFileLibrary) GetFileNameOnDisk(fileID int) (string, bool) {
 	filename, exists := library.FilesByID[fileID]
@@ -0,0 +1,169 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audiofilelibrary
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/TheCacophonyProject/audiobait/v3/audio"
+)
+
+// IndexFilename is the file in the audio directory that describes each audio file.
+const IndexFilename = "library.json"
+
+// FileInfo describes an audio file in the library.
+type FileInfo struct {
+	ID int `json:"id"`
+	// Name is the name of the file on the API server.
+	Name string `json:"name"`
+	// OriginalName is the name of the file when it was uploaded to the API server.
+	OriginalName string `json:"originalName"`
+	// FileName is the name of the file in the audio directory.
+	FileName string `json:"fileName"`
+	Size     int64  `json:"size"`
+	// SHA256 is the hex encoded SHA-256 hash of the file's contents.
+	SHA256 string `json:"sha256"`
+	// Duration, SampleRate and Channels are as the file is encoded. They are
+	// zero for files that can't be decoded.
+	Duration   time.Duration `json:"duration"`
+	SampleRate int           `json:"sampleRate"`
+	Channels   int           `json:"channels"`
+	// Downloaded is when the file was added to the audio directory.
+	Downloaded time.Time `json:"downloaded"`
+}
+
+// FileInfo returns the index entry for the audio file with the ID. It returns
+// false if the file isn't in the index.
+func (library *AudioFileLibrary) FileInfo(fileID int) (FileInfo, bool) {
+	info, exists := library.index[fileID]
+	return info, exists
+}
+
+// Files returns the index entries for the files in the library, ordered by ID.
+func (library *AudioFileLibrary) Files() []FileInfo {
+	files := make([]FileInfo, 0, len(library.index))
+	for _, info := range library.index {
+		files = append(files, info)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+	return files
+}
+
+// AddToIndex adds an audio file that is in the library to the index, replacing
+// any entry already there for its ID. The ID, Name, OriginalName and Downloaded
+// are taken from info, with Downloaded being the time the file was last
+// modified if it is zero. The rest is worked out from the file.
+func (library *AudioFileLibrary) AddToIndex(info FileInfo) (FileInfo, error) {
+	fileName, exists := library.FilesByID[info.ID]
+	if !exists {
+		return info, os.ErrNotExist
+	}
+	path := filepath.Join(library.soundsDirectory, fileName)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return info, err
+	}
+	hash, err := HashFile(path)
+	if err != nil {
+		return info, err
+	}
+	info.FileName = fileName
+	info.Size = stat.Size()
+	info.SHA256 = hash
+	if info.Downloaded.IsZero() {
+		info.Downloaded = stat.ModTime()
+	}
+	probed, err := audio.Probe(path)
+	if err != nil {
+		log.Printf("could not read the format of %s: %v", fileName, err)
+	}
+	info.Duration = probed.Duration
+	info.SampleRate = probed.SampleRate
+	info.Channels = probed.Channels
+
+	if library.index == nil {
+		library.index = make(map[int]FileInfo)
+	}
+	library.index[info.ID] = info
+	return info, library.saveIndex()
+}
+
+// HashFile gives the hex encoded SHA-256 hash of a file's contents.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (library *AudioFileLibrary) saveIndex() error {
+	data, err := json.MarshalIndent(library.Files(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(library.soundsDirectory, IndexFilename), data)
+}
+
+// readIndex loads the index entries for the files in the library.
+func (library *AudioFileLibrary) readIndex() {
+	library.index = make(map[int]FileInfo)
+	data, err := ioutil.ReadFile(filepath.Join(library.soundsDirectory, IndexFilename))
+	if os.IsNotExist(err) {
+		return
+	}
+	var files []FileInfo
+	if err == nil {
+		err = json.Unmarshal(data, &files)
+	}
+	if err != nil {
+		log.Printf("could not read %s: %v", IndexFilename, err)
+		return
+	}
+	for _, info := range files {
+		// Leave out files that have gone from the audio directory.
+		if library.FilesByID[info.ID] == info.FileName {
+			library.index[info.ID] = info
+		}
+	}
+}
+
+// writeFileAtomic writes a file by way of a temporary file, so that it is never
+// left half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,67 @@
+package audiofilelibrary
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiofilelibrary-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeSquareWave(t, filepath.Join(dir, "bellbird-2.wav"), 1000)
+	mp3, err := ioutil.ReadFile("../audio/testdata/speech.mp3")
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "morepork-1.mp3"), mp3, 0644))
+
+	library, err := OpenLibrary(dir)
+	require.NoError(t, err)
+	downloaded := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	info, err := library.AddToIndex(FileInfo{ID: 2, Name: "bellbird", OriginalName: "Bellbird.wav", Downloaded: downloaded})
+	require.NoError(t, err)
+	assert.Equal(t, FileInfo{
+		ID:           2,
+		Name:         "bellbird",
+		OriginalName: "Bellbird.wav",
+		FileName:     "bellbird-2.wav",
+		Size:         2044,
+		SHA256:       info.SHA256,
+		Duration:     125 * time.Millisecond,
+		SampleRate:   8000,
+		Channels:     1,
+		Downloaded:   downloaded,
+	}, info)
+	assert.Len(t, info.SHA256, 64)
+	_, err = library.AddToIndex(FileInfo{ID: 1, Name: "morepork"})
+	require.NoError(t, err)
+	_, err = library.AddToIndex(FileInfo{ID: 3})
+	assert.True(t, os.IsNotExist(err))
+
+	library, err = OpenLibrary(dir)
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{1: "morepork-1.mp3", 2: "bellbird-2.wav"}, library.FilesByID)
+	indexed, found := library.FileInfo(2)
+	assert.True(t, found)
+	assert.True(t, indexed.Downloaded.Equal(downloaded))
+	files := library.Files()
+	require.Len(t, files, 2)
+	assert.Equal(t, 1, files[0].ID)
+	assert.Equal(t, int64(len(mp3)), files[0].Size)
+	assert.Equal(t, 22050, files[0].SampleRate)
+	assert.Equal(t, 1, files[0].Channels, "the mono MP3 is decoded as stereo but is indexed as it is encoded")
+	assert.Equal(t, 23040*time.Second/22050, files[0].Duration)
+	assert.False(t, files[0].Downloaded.IsZero())
+
+	// Files that are gone are left out of the index.
+	require.NoError(t, os.Remove(filepath.Join(dir, "bellbird-2.wav")))
+	library, err = OpenLibrary(dir)
+	require.NoError(t, err)
+	_, found = library.FileInfo(2)
+	assert.False(t, found)
+}
@@ -0,0 +1,142 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audiofilelibrary
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/TheCacophonyProject/audiobait/v3/audio"
+)
+
+// LoudnessFilename is the file in the audio directory that holds the loudness of
+// each audio file, keyed by file name.
+const LoudnessFilename = "loudness.json"
+
+// ErrSilent is returned when measuring the loudness of a file with no sound in it.
+var ErrSilent = errors.New("audio file is silent")
+
+// MeasureLoudness gives the RMS loudness of an audio file in dB relative to full scale.
+func MeasureLoudness(path string) (float64, error) {
+	f, err := audio.OpenFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var sumSquares float64
+	var count int
+	samples := make([]int16, 4096)
+	for {
+		n, err := f.ReadSamples(samples)
+		for _, s := range samples[:n] {
+			v := float64(s) / -math.MinInt16
+			sumSquares += v * v
+		}
+		count += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if sumSquares == 0 {
+		return 0, ErrSilent
+	}
+	return 10 * math.Log10(sumSquares/float64(count)), nil
+}
+
+// Loudness returns the measured RMS loudness, in dB relative to full scale, of
+// the audio file with the ID. It returns false if the file hasn't been measured.
+func (library *AudioFileLibrary) Loudness(fileID int) (float64, bool) {
+	filename, exists := library.FilesByID[fileID]
+	if !exists {
+		return 0, false
+	}
+	loudness, measured := library.loudness[filename]
+	return loudness, measured
+}
+
+// MeasureLoudness measures the loudness of the audio files with the IDs that
+// haven't been measured yet, and saves the results. Files that can't be measured
+// are logged and skipped.
+func (library *AudioFileLibrary) MeasureLoudness(fileIDs []int) error {
+	changed := false
+	for _, fileID := range fileIDs {
+		filename, exists := library.FilesByID[fileID]
+		if !exists {
+			continue
+		}
+		if _, measured := library.loudness[filename]; measured {
+			continue
+		}
+		loudness, err := MeasureLoudness(filepath.Join(library.soundsDirectory, filename))
+		if err != nil {
+			log.Printf("could not measure loudness of %s: %v", filename, err)
+			continue
+		}
+		log.Printf("loudness of %s is %.1fdB", filename, loudness)
+		library.loudness[filename] = loudness
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return library.saveLoudness()
+}
+
+// forgetLoudness removes the loudness measured for a file.
+func (library *AudioFileLibrary) forgetLoudness(fileName string) error {
+	if _, measured := library.loudness[fileName]; !measured {
+		return nil
+	}
+	delete(library.loudness, fileName)
+	return library.saveLoudness()
+}
+
+func (library *AudioFileLibrary) saveLoudness() error {
+	data, err := json.MarshalIndent(library.loudness, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(library.soundsDirectory, LoudnessFilename), data)
+}
+
+// readLoudness loads the loudness of the files in the library that have been measured.
+func (library *AudioFileLibrary) readLoudness() {
+	library.loudness = make(map[string]float64)
+	data, err := ioutil.ReadFile(filepath.Join(library.soundsDirectory, LoudnessFilename))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &library.loudness)
+	}
+	if err != nil {
+		log.Printf("could not read %s: %v", LoudnessFilename, err)
+		library.loudness = make(map[string]float64)
+	}
+}
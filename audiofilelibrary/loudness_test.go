@@ -0,0 +1,71 @@
+package audiofilelibrary
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TheCacophonyProject/audiobait/v3/audio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSquareWave writes a WAV file of a square wave with the amplitude.
+func writeSquareWave(t *testing.T, path string, amplitude int16) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = amplitude
+		if i%2 == 1 {
+			samples[i] = -amplitude
+		}
+	}
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, audio.WriteWAV(f, audio.Format{SampleRate: 8000, Channels: 1}, samples))
+}
+
+func TestMeasureLoudness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiofilelibrary-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "half-1.wav")
+	writeSquareWave(t, path, 16384)
+	loudness, err := MeasureLoudness(path)
+	require.NoError(t, err)
+	assert.InDelta(t, -6.02, loudness, 0.01)
+
+	loudness, err = MeasureLoudness("../audio/testdata/speech.mp3")
+	require.NoError(t, err)
+	assert.InDelta(t, -20, loudness, 10)
+
+	path = filepath.Join(dir, "silent-2.wav")
+	writeSquareWave(t, path, 0)
+	_, err = MeasureLoudness(path)
+	assert.Equal(t, ErrSilent, err)
+}
+
+func TestLibraryLoudnessIsSaved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiofilelibrary-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeSquareWave(t, filepath.Join(dir, "full-1.wav"), -32768)
+	writeSquareWave(t, filepath.Join(dir, "silent-2.wav"), 0)
+
+	library, err := OpenLibrary(dir)
+	require.NoError(t, err)
+	_, measured := library.Loudness(1)
+	assert.False(t, measured)
+	require.NoError(t, library.MeasureLoudness([]int{1, 2, 3}))
+
+	library, err = OpenLibrary(dir)
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{1: "full-1.wav", 2: "silent-2.wav"}, library.FilesByID)
+	loudness, measured := library.Loudness(1)
+	assert.True(t, measured)
+	assert.InDelta(t, 0, loudness, 0.001)
+	_, measured = library.Loudness(2)
+	assert.False(t, measured)
+}
@@ -0,0 +1,112 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audiofilelibrary
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// QuarantineDirname is the directory in the audio directory that corrupt audio
+// files are moved to.
+const QuarantineDirname = "quarantine"
+
+// ErrCorrupt is returned when an audio file's contents don't match its hash in the index.
+var ErrCorrupt = errors.New("audio file is corrupt")
+
+// Verify checks that the contents of the audio file with the ID match the hash
+// recorded in the index when it was downloaded. It returns an error wrapping
+// ErrCorrupt if they don't. Files that aren't in the index can't be checked, so
+// nil is returned for them.
+func (library *AudioFileLibrary) Verify(fileID int) error {
+	info, indexed := library.index[fileID]
+	if !indexed {
+		return nil
+	}
+	hash, err := HashFile(filepath.Join(library.soundsDirectory, info.FileName))
+	if err != nil {
+		return err
+	}
+	if hash != info.SHA256 {
+		return fmt.Errorf("%w: %s has hash %s instead of %s", ErrCorrupt, info.FileName, hash, info.SHA256)
+	}
+	return nil
+}
+
+// Quarantine moves the audio file with the ID out of the library into the
+// quarantine directory, so that it is fetched again, and removes it from the
+// index. Its loudness is forgotten so the new copy is measured.
+func (library *AudioFileLibrary) Quarantine(fileID int) error {
+	fileName, exists := library.FilesByID[fileID]
+	if !exists {
+		return os.ErrNotExist
+	}
+	dir := filepath.Join(library.soundsDirectory, QuarantineDirname)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Keep earlier copies that were quarantined.
+	dst := filepath.Join(dir, fileName)
+	for i := 1; fileExists(dst); i++ {
+		dst = filepath.Join(dir, fileName+"."+strconv.Itoa(i))
+	}
+	if err := os.Rename(filepath.Join(library.soundsDirectory, fileName), dst); err != nil {
+		return err
+	}
+	delete(library.FilesByID, fileID)
+	if err := library.forgetLoudness(fileName); err != nil {
+		return err
+	}
+	if _, indexed := library.index[fileID]; !indexed {
+		return nil
+	}
+	delete(library.index, fileID)
+	return library.saveIndex()
+}
+
+// VerifyAll checks every audio file in the index, quarantining those that are
+// corrupt. It returns the IDs of the files that were quarantined.
+func (library *AudioFileLibrary) VerifyAll() []int {
+	var quarantined []int
+	for _, info := range library.Files() {
+		err := library.Verify(info.ID)
+		if err == nil {
+			continue
+		}
+		log.Printf("could not verify %s: %v", info.FileName, err)
+		if !errors.Is(err, ErrCorrupt) {
+			continue
+		}
+		if err := library.Quarantine(info.ID); err != nil {
+			log.Printf("could not quarantine %s: %v", info.FileName, err)
+			continue
+		}
+		quarantined = append(quarantined, info.ID)
+	}
+	return quarantined
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,78 @@
+package audiofilelibrary
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyAndQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiofilelibrary-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "morepork-1.mp3")
+	require.NoError(t, ioutil.WriteFile(path, []byte("sound"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "kaka-2.mp3"), []byte("other"), 0644))
+
+	library, err := OpenLibrary(dir)
+	require.NoError(t, err)
+	assert.NoError(t, library.Verify(1), "files that aren't indexed can't be checked")
+	_, err = library.AddToIndex(FileInfo{ID: 1})
+	require.NoError(t, err)
+	_, err = library.AddToIndex(FileInfo{ID: 2})
+	require.NoError(t, err)
+	assert.NoError(t, library.Verify(1))
+
+	// Corrupt the file without changing its size.
+	require.NoError(t, ioutil.WriteFile(path, []byte("sourd"), 0644))
+	library, err = OpenLibrary(dir)
+	require.NoError(t, err)
+	assert.True(t, errors.Is(library.Verify(1), ErrCorrupt))
+	assert.Equal(t, []int{1}, library.VerifyAll())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, QuarantineDirname, "morepork-1.mp3"))
+	require.NoError(t, err)
+	assert.Equal(t, "sourd", string(data))
+	library, err = OpenLibrary(dir)
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{2: "kaka-2.mp3"}, library.FilesByID)
+	_, indexed := library.FileInfo(1)
+	assert.False(t, indexed)
+
+	// A second bad copy doesn't replace the first one.
+	require.NoError(t, ioutil.WriteFile(path, []byte("again"), 0644))
+	library, err = OpenLibrary(dir)
+	require.NoError(t, err)
+	require.NoError(t, library.Quarantine(1))
+	data, err = ioutil.ReadFile(filepath.Join(dir, QuarantineDirname, "morepork-1.mp3.1"))
+	require.NoError(t, err)
+	assert.Equal(t, "again", string(data))
+}
+
+func TestQuarantineForgetsLoudness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiofilelibrary-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tone-1.wav")
+	writeSquareWave(t, path, 1000)
+	library, err := OpenLibrary(dir)
+	require.NoError(t, err)
+	require.NoError(t, library.MeasureLoudness([]int{1}))
+	require.NoError(t, library.Quarantine(1))
+
+	// The new copy of the file is measured instead of using the old loudness.
+	writeSquareWave(t, path, 2000)
+	library, err = OpenLibrary(dir)
+	require.NoError(t, err)
+	_, measured := library.Loudness(1)
+	assert.False(t, measured)
+	require.NoError(t, library.MeasureLoudness([]int{1}))
+	loudness, measured := library.Loudness(1)
+	assert.True(t, measured)
+	assert.InDelta(t, -24.3, loudness, 0.1)
+}
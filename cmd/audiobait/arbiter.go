@@ -0,0 +1,112 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Reasons for a sound not being played.
+const (
+	reasonHigherPriority = "a higher priority sound is playing"
+	reasonPreempted      = "stopped for a higher priority sound"
+)
+
+// arbiter decides which sound plays when more than one is asked for at once.
+// A sound is refused while a higher priority sound is playing and waits for a
+// sound with the same priority to finish. A lower priority sound that is playing
+// is stopped if preempt is set, otherwise it is waited for.
+type arbiter struct {
+	preempt bool
+
+	mu      sync.Mutex
+	changed *sync.Cond
+	playing *playback
+	// waiting counts the sounds waiting to play by priority.
+	waiting map[int]int
+}
+
+type playback struct {
+	priority int
+	stop     context.CancelFunc
+}
+
+func newArbiter(preempt bool) *arbiter {
+	a := &arbiter{
+		preempt: preempt,
+		waiting: make(map[int]int),
+	}
+	a.changed = sync.NewCond(&a.mu)
+	return a
+}
+
+// acquire waits until a sound with the given priority can be played. It returns
+// a context that is cancelled if the sound is preempted, and a function that must
+// be called once the sound has finished. If the sound can't be played the reason
+// is returned instead.
+func (a *arbiter) acquire(priority int) (context.Context, func(), string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.waiting[priority]++
+	defer func() {
+		a.waiting[priority]--
+		if a.waiting[priority] == 0 {
+			delete(a.waiting, priority)
+		}
+	}()
+	for a.playing != nil || a.higherWaiting(priority) {
+		if a.playing != nil {
+			if a.playing.priority > priority {
+				return nil, nil, reasonHigherPriority
+			}
+			if a.playing.priority < priority && a.preempt {
+				a.playing.stop()
+			}
+		}
+		a.changed.Wait()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &playback{priority: priority, stop: cancel}
+	a.playing = p
+	// Let the sounds still waiting see if they should give up.
+	a.changed.Broadcast()
+	release := func() {
+		cancel()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.playing == p {
+			a.playing = nil
+		}
+		a.changed.Broadcast()
+	}
+	return ctx, release, ""
+}
+
+// higherWaiting checks if a sound with a higher priority is waiting to play. a.mu must be held.
+func (a *arbiter) higherWaiting(priority int) bool {
+	for p := range a.waiting {
+		if p > priority {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/event-reporter/eventclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// acquireLater tries to acquire the arbiter in the background, sending the
+// reason it couldn't, or "" once it has.
+func acquireLater(a *arbiter, priority int) <-chan string {
+	result := make(chan string, 1)
+	go func() {
+		_, _, reason := a.acquire(priority)
+		result <- reason
+	}()
+	return result
+}
+
+// waitingFor waits until a sound with the priority is waiting to play.
+func waitingFor(a *arbiter, priority int) {
+	for {
+		a.mu.Lock()
+		n := a.waiting[priority]
+		a.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHigherPriorityPlayingRejects(t *testing.T) {
+	a := newArbiter(true)
+	_, release, reason := a.acquire(5)
+	assert.Equal(t, "", reason)
+
+	_, _, reason = a.acquire(1)
+	assert.Equal(t, reasonHigherPriority, reason)
+
+	release()
+	_, _, reason = a.acquire(1)
+	assert.Equal(t, "", reason)
+}
+
+func TestSamePriorityIsQueued(t *testing.T) {
+	a := newArbiter(true)
+	_, release, _ := a.acquire(1)
+
+	result := acquireLater(a, 1)
+	waitingFor(a, 1)
+	select {
+	case <-result:
+		t.Fatal("played while another sound with the same priority was playing")
+	default:
+	}
+
+	release()
+	assert.Equal(t, "", <-result)
+}
+
+func TestLowerPriorityIsPreempted(t *testing.T) {
+	a := newArbiter(true)
+	ctx, release, _ := a.acquire(1)
+
+	result := acquireLater(a, 5)
+	<-ctx.Done()
+	release()
+	assert.Equal(t, "", <-result)
+}
+
+func TestLowerPriorityIsWaitedForWithoutPreempt(t *testing.T) {
+	a := newArbiter(false)
+	ctx, release, _ := a.acquire(1)
+
+	result := acquireLater(a, 5)
+	waitingFor(a, 5)
+	assert.NoError(t, ctx.Err())
+
+	// Sounds with a lower priority than one waiting are refused once it plays.
+	lowResult := acquireLater(a, 3)
+	waitingFor(a, 3)
+
+	release()
+	assert.Equal(t, "", <-result)
+	assert.Equal(t, reasonHigherPriority, <-lowResult)
+}
+
+// blockingSoundCard plays until the context is cancelled or it is stopped.
+type blockingSoundCard struct {
+	started chan struct{}
+	stop    chan struct{}
+}
+
+func newBlockingSoundCard() blockingSoundCard {
+	return blockingSoundCard{
+		started: make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+}
+
+func (sc blockingSoundCard) Play(ctx context.Context, audioFileName string, volume int, gainDB float64) error {
+	close(sc.started)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-sc.stop:
+		return errStopped
+	}
+}
+
+func (sc blockingSoundCard) Stop() bool {
+	close(sc.stop)
+	return true
+}
+
+func TestPreemptedSoundIsNotPlayed(t *testing.T) {
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+	event := mockSaveEvent(nil)
+	soundCard := newBlockingSoundCard()
+	testPlayer := player{soundCard: soundCard, arbiter: newArbiter(true)}
+
+	go func() {
+		<-soundCard.started
+		testPlayer.arbiter.acquire(5)
+	}()
+	played, reason, err := testPlayer.PlayFromId(1, 2, 1, &eventclient.Event{})
+	assert.NoError(t, err)
+	assert.False(t, played)
+	assert.Equal(t, reasonPreempted, reason)
+	assert.Nil(t, *event)
+}
@@ -22,15 +22,24 @@ import (
 	"log"
 	"time"
 
+	"github.com/TheCacophonyProject/audiobait/v3/playlist"
 	"github.com/TheCacophonyProject/event-reporter/eventclient"
 )
 
-// AudioBaitEventRecorder uses the event api to record that audioBait was played at a particular time.
+// AudioBaitEventRecorder uses the event api to record that audioBait was played at a particular time,
+// and the nights and combos where it wasn't.
 type AudioBaitEventRecorder struct {
+	// lastNight stops a night being recorded more than once when the schedule is replayed.
+	lastNight playlist.Date
+	// nightDetails describes the schedule and cycle of the current night. They are
+	// added to the events for skipped combos and failed plays.
+	nightDetails map[string]interface{}
+	// calibration is recorded in every event.
+	calibration calibration
 }
 
 // OnAudioBaitPlayed logs an occurrence of an audiobait being played.
-func (er AudioBaitEventRecorder) OnAudioBaitPlayed(ts time.Time, fileID int, volume int) {
+func (er *AudioBaitEventRecorder) OnAudioBaitPlayed(ts time.Time, fileID int, volume int) {
 	event := eventclient.Event{
 		Timestamp: ts,
 		Type:      "audioBait",
@@ -39,8 +48,80 @@ func (er AudioBaitEventRecorder) OnAudioBaitPlayed(ts time.Time, fileID int, vol
 			"volume": volume,
 		},
 	}
+	er.calibration.addDetails(event.Details, volume)
 
-	if err := eventclient.AddEvent(event); err != nil {
+	if err := saveEvent(event); err != nil {
+		log.Println(err)
+	}
+}
+
+// OnPlayNightStart records the start of a night where sounds will be played.
+func (er *AudioBaitEventRecorder) OnPlayNightStart(ts time.Time, schedule playlist.Schedule, night playlist.CalendarNight) {
+	er.onNightStart(ts, "audioBaitPlayNight", schedule, night)
+}
+
+// OnControlNightStart records the start of a night where no sounds will be played.
+func (er *AudioBaitEventRecorder) OnControlNightStart(ts time.Time, schedule playlist.Schedule, night playlist.CalendarNight) {
+	er.onNightStart(ts, "audioBaitControlNight", schedule, night)
+}
+
+func (er *AudioBaitEventRecorder) onNightStart(ts time.Time, eventType string, schedule playlist.Schedule, night playlist.CalendarNight) {
+	if night.Date == er.lastNight {
+		return
+	}
+	er.lastNight = night.Date
+	er.nightDetails = map[string]interface{}{
+		"schedule":      schedule.Description,
+		"playNights":    schedule.PlayNights,
+		"controlNights": schedule.ControlNights,
+		"date":          night.Date.Format("2006-01-02"),
+		"design":        night.Design,
+		"seed":          night.Seed,
+		"block":         night.Block,
+		"dayOfCycle":    night.DayOfCycle,
+	}
+	er.save(ts, eventType, nil)
+}
+
+// OnComboSkipped records a combo that finished without playing any sounds.
+func (er *AudioBaitEventRecorder) OnComboSkipped(ts time.Time, comboIndex int, reason string) {
+	er.save(ts, "audioBaitComboSkipped", map[string]interface{}{
+		"combo":  comboIndex,
+		"reason": reason,
+	})
+}
+
+// OnPlayFailed records a sound from a combo that couldn't be played. Sounds
+// not played because audiobait is muted aren't recorded, as the player has
+// already saved an audioBaitMuted event for them.
+func (er *AudioBaitEventRecorder) OnPlayFailed(ts time.Time, fileID int, volume int, reason string) {
+	if reason == reasonMuted {
+		return
+	}
+	er.save(ts, "audioBaitPlayFailed", map[string]interface{}{
+		"fileId": fileID,
+		"volume": volume,
+		"reason": reason,
+	})
+}
+
+// save adds the current night's details and the calibration to the event details
+// and saves the event.
+func (er *AudioBaitEventRecorder) save(ts time.Time, eventType string, details map[string]interface{}) {
+	event := eventclient.Event{
+		Timestamp: ts,
+		Type:      eventType,
+		Details:   map[string]interface{}{},
+	}
+	for k, v := range er.nightDetails {
+		event.Details[k] = v
+	}
+	for k, v := range details {
+		event.Details[k] = v
+	}
+	volume, _ := details["volume"].(int)
+	er.calibration.addDetails(event.Details, volume)
+	if err := saveEvent(event); err != nil {
 		log.Println(err)
 	}
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/audiobait/v3/playlist"
+	"github.com/TheCacophonyProject/event-reporter/eventclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlNightIsRecordedOnce(t *testing.T) {
+	var events []eventclient.Event
+	saveEvent = func(e eventclient.Event) error {
+		events = append(events, e)
+		return nil
+	}
+	schedule := playlist.Schedule{Description: "test", PlayNights: 1, ControlNights: 2}
+	night := playlist.CalendarNight{
+		Date:       playlist.NewDate(2021, time.April, 2),
+		Design:     playlist.DesignAlternating,
+		DayOfCycle: 1,
+	}
+	ts := time.Date(2021, time.April, 2, 19, 0, 0, 0, time.UTC)
+
+	recorder := &AudioBaitEventRecorder{}
+	recorder.OnControlNightStart(ts, schedule, night)
+	recorder.OnControlNightStart(ts.Add(time.Hour), schedule, night)
+	recorder.OnComboSkipped(ts, 1, "window ended")
+
+	assert.Len(t, events, 2)
+	assert.Equal(t, "audioBaitControlNight", events[0].Type)
+	assert.Equal(t, "test", events[0].Details["schedule"])
+	assert.Equal(t, 1, events[0].Details["dayOfCycle"])
+	assert.Equal(t, "audioBaitComboSkipped", events[1].Type)
+	assert.Equal(t, "test", events[1].Details["schedule"])
+	assert.Equal(t, "window ended", events[1].Details["reason"])
+}
+
+func TestCalibrationIsRecordedInEveryEvent(t *testing.T) {
+	var events []eventclient.Event
+	saveEvent = func(e eventclient.Event) error {
+		events = append(events, e)
+		return nil
+	}
+	night := playlist.CalendarNight{Date: playlist.NewDate(2021, time.April, 2), Play: true}
+	ts := time.Date(2021, time.April, 2, 19, 0, 0, 0, time.UTC)
+
+	recorder := &AudioBaitEventRecorder{calibration: testCalibration}
+	recorder.OnPlayNightStart(ts, playlist.Schedule{}, night)
+	recorder.OnComboSkipped(ts, 0, "window ended")
+	recorder.OnPlayFailed(ts, 3, 10, "sound card failure")
+	recorder.OnAudioBaitPlayed(ts, 3, 10)
+
+	assert.Len(t, events, 4)
+	for _, event := range events {
+		assert.Equal(t, testCalibration.Name, event.Details["calibration"], event.Type)
+	}
+	assert.Equal(t, testCalibration.Gains[9], events[2].Details["gainDb"])
+	assert.NotContains(t, events[1].Details, "gainDb")
+}
+
+func TestMutedPlayIsRecordedOnce(t *testing.T) {
+	var events []eventclient.Event
+	saveEvent = func(e eventclient.Event) error {
+		events = append(events, e)
+		return nil
+	}
+	newFakeNow()
+	path, cleanup := tempMuteFile(t)
+	defer cleanup()
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+	testPlayer := player{
+		soundCard: &recordingSoundCard{},
+		arbiter:   newArbiter(true),
+		muter:     newMuter(path),
+	}
+	require.NoError(t, testPlayer.muter.mute(3, time.Hour))
+	recorder := &AudioBaitEventRecorder{}
+
+	// What the schedule player does when a sound isn't played.
+	ts := now()
+	played, reason, err := testPlayer.PlayFromId(1, 2, 1, &eventclient.Event{Type: "audioBait"})
+	require.NoError(t, err)
+	require.False(t, played)
+	recorder.OnPlayFailed(ts, 1, 2, reason)
+
+	require.Len(t, events, 1)
+	assert.Equal(t, "audioBaitMuted", events[0].Type)
+}
@@ -0,0 +1,103 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxVolume is the loudest volume on the lure scale.
+const maxVolume = 10
+
+// uncalibrated is given as the calibration in events when there is no calibration profile.
+const uncalibrated = "none"
+
+// calibration is a profile that maps the lure volume scale of 1 to 10 to a gain
+// in dB for a device, so that a volume means the same sound level on different
+// speakers and amplifiers.
+type calibration struct {
+	// Name identifies the profile in events, such as the speaker it was measured for.
+	Name string `mapstructure:"name"`
+	// Gains are the gains in dB for volumes 1 to 10. When there are none the device
+	// is uncalibrated and the volume is a percentage of full scale.
+	Gains []float64 `mapstructure:"gains"`
+}
+
+// calibrated returns true if there is a calibration profile.
+func (c calibration) calibrated() bool {
+	return len(c.Gains) > 0
+}
+
+func (c calibration) validate() error {
+	if !c.calibrated() {
+		return nil
+	}
+	if c.Name == "" {
+		return errors.New("calibration profile has no name")
+	}
+	if len(c.Gains) != maxVolume {
+		return fmt.Errorf("calibration profile '%s' has %d gains instead of %d", c.Name, len(c.Gains), maxVolume)
+	}
+	for i := 1; i < len(c.Gains); i++ {
+		if c.Gains[i] < c.Gains[i-1] {
+			return fmt.Errorf("calibration profile '%s' is quieter at volume %d than at %d", c.Name, i+1, i)
+		}
+	}
+	return nil
+}
+
+// gainDB gives the gain for a volume from the profile. It returns false if the
+// device is uncalibrated or the volume is silent.
+func (c calibration) gainDB(volume int) (float64, bool) {
+	if !c.calibrated() || volume < 1 {
+		return 0, false
+	}
+	if volume > maxVolume {
+		volume = maxVolume
+	}
+	return c.Gains[volume-1], true
+}
+
+// amplitude gives how much to scale samples by for a volume.
+func (c calibration) amplitude(volume int) float64 {
+	if volume < 1 {
+		return 0
+	}
+	if db, ok := c.gainDB(volume); ok {
+		return math.Pow(10, db/20)
+	}
+	if volume > maxVolume {
+		volume = maxVolume
+	}
+	return float64(volume) / maxVolume
+}
+
+// addDetails records the calibration used for a sound in an event's details.
+func (c calibration) addDetails(details map[string]interface{}, volume int) {
+	if !c.calibrated() {
+		details["calibration"] = uncalibrated
+		return
+	}
+	details["calibration"] = c.Name
+	if db, ok := c.gainDB(volume); ok {
+		details["gainDb"] = db
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/TheCacophonyProject/event-reporter/eventclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testCalibration = calibration{
+	Name:  "speaker-a",
+	Gains: []float64{-40, -36, -32, -28, -24, -20, -16, -12, -6, 0},
+}
+
+func TestCalibrationValidate(t *testing.T) {
+	assert.NoError(t, calibration{}.validate())
+	assert.NoError(t, testCalibration.validate())
+	assert.Error(t, calibration{Gains: testCalibration.Gains}.validate())
+	assert.Error(t, calibration{Name: "short", Gains: []float64{-6, 0}}.validate())
+	decreasing := calibration{Name: "bad", Gains: append([]float64{}, testCalibration.Gains...)}
+	decreasing.Gains[4] = -50
+	assert.Error(t, decreasing.validate())
+}
+
+func TestCalibrationGain(t *testing.T) {
+	db, ok := testCalibration.gainDB(7)
+	assert.True(t, ok)
+	assert.Equal(t, -16.0, db)
+	_, ok = testCalibration.gainDB(0)
+	assert.False(t, ok)
+	_, ok = calibration{}.gainDB(7)
+	assert.False(t, ok)
+
+	assert.Equal(t, 1.0, testCalibration.amplitude(10))
+	assert.InDelta(t, 0.5, testCalibration.amplitude(9), 0.002)
+	assert.Equal(t, 0.0, testCalibration.amplitude(0))
+	assert.Equal(t, 0.7, calibration{}.amplitude(7))
+
+	assert.Equal(t, "-16.00dB", (&amixerPlayer{calibration: testCalibration}).mixerLevel(7))
+	assert.Equal(t, "70%", (&amixerPlayer{}).mixerLevel(7))
+}
+
+func TestCalibrationIsRecordedInEvents(t *testing.T) {
+	newFakeNow()
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+	event := mockSaveEvent(nil)
+	testPlayer := player{
+		soundCard:   newMockSoundCard(nil),
+		arbiter:     newArbiter(true),
+		calibration: testCalibration,
+	}
+	played, _, err := testPlayer.PlayFromId(1, 7, 3, &eventclient.Event{})
+	require.NoError(t, err)
+	assert.True(t, played)
+	assert.Equal(t, "speaker-a", (*event).Details["calibration"])
+	assert.Equal(t, -16.0, (*event).Details["gainDb"])
+}
@@ -19,10 +19,123 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/TheCacophonyProject/audiobait/v3/playlist"
 	goconfig "github.com/TheCacophonyProject/go-config"
 )
 
-func ParseConfig(configDir string) (*goconfig.Audio, error) {
+// audiobaitKey is the config section for settings that only audiobait uses.
+const audiobaitKey = "audiobait"
+
+// Ways of playing sounds, see audiobaitConfig.Backend.
+const (
+	backendSox    = "sox"
+	backendNative = "native"
+	backendFile   = "file"
+)
+
+type Config struct {
+	goconfig.Audio
+	Location playlist.Location
+	audiobaitConfig
+}
+
+type audiobaitConfig struct {
+	// ScheduleSources are where new schedules come from: "api", "local" and "removable".
+	// A schedule on removable media is used while the drive is there, then a
+	// local one while it is there, and otherwise the one from the API.
+	ScheduleSources []string `mapstructure:"schedule-sources"`
+	// ScheduleDir is the directory the "local" source reads a schedule bundle from.
+	ScheduleDir string `mapstructure:"schedule-directory"`
+	// MediaDirs are where removable drives are mounted.
+	MediaDirs []string `mapstructure:"media-directories"`
+	// TriggerSignals are the D-Bus interfaces whose signals are used as triggers.
+	// The trigger is named after the signal.
+	TriggerSignals []string `mapstructure:"trigger-signals"`
+	// Preempt stops a sound that is playing when a sound with a higher priority is asked for.
+	// It is off by default, so a sound waits for the one playing to finish.
+	Preempt bool `mapstructure:"preempt"`
+	// MuteFile is where the mute is saved so it lasts over a restart. Defaults
+	// to mute.json in the audio directory.
+	MuteFile string `mapstructure:"mute-file"`
+	// Backend is how sounds are played: "sox" plays them with sox and sets the
+	// volume with amixer, "native" decodes them and plays them with aplay, and
+	// "file" decodes them and writes them to OutputDir instead of playing them.
+	// The "native" and "file" backends decode WAV, MP3 and Ogg Vorbis files.
+	// The "native" backend needs aplay, from alsa-utils, to be installed.
+	Backend string `mapstructure:"backend"`
+	// OutputDevice is the ALSA device the "native" backend plays sounds on.
+	OutputDevice string `mapstructure:"output-device"`
+	// OutputDir is where the "file" backend writes sounds. Defaults to the
+	// output directory in the audio directory.
+	OutputDir string `mapstructure:"output-directory"`
+	// Calibration maps volumes to gains for the device. It is applied by the mixer
+	// with the "sox" backend and to the samples with the others.
+	Calibration calibration `mapstructure:"calibration"`
+	// NormaliseLoudness corrects the gain of each audio file so that it plays at
+	// TargetLoudness, the RMS loudness in dB relative to full scale, before the
+	// volume is applied. Files are measured when a schedule is downloaded or imported.
+	NormaliseLoudness bool    `mapstructure:"normalise-loudness"`
+	TargetLoudness    float64 `mapstructure:"target-loudness"`
+	// Triggers are the sounds to play for triggers that aren't in the schedule.
+	Triggers map[string]triggerSounds `mapstructure:"triggers"`
+}
+
+// triggerSounds are the sounds played for a trigger, chosen like the sounds in a combo.
+type triggerSounds struct {
+	Sounds []string `mapstructure:"sounds"`
+	Volume int      `mapstructure:"volume"`
+}
+
+// setDefaultPaths puts the files audiobait keeps that haven't been configured
+// in the audio directory.
+func (c *audiobaitConfig) setDefaultPaths(audioDir string) {
+	if c.MuteFile == "" {
+		c.MuteFile = filepath.Join(audioDir, "mute.json")
+	}
+	if c.OutputDir == "" {
+		c.OutputDir = filepath.Join(audioDir, "output")
+	}
+}
+
+// validate checks the sounds for a trigger can be played, like Schedule.Validate
+// does for the sounds in a combo. Whether the files are in the library is only
+// known when the trigger fires, as they may be downloaded later.
+func (ts triggerSounds) validate(trigger string) error {
+	if len(ts.Sounds) == 0 {
+		return fmt.Errorf("trigger '%s' has no sounds", trigger)
+	}
+	if ts.Volume < playlist.MinVolume || ts.Volume > playlist.MaxVolume {
+		return fmt.Errorf("trigger '%s' volume must be between %d and %d (got %d)", trigger, playlist.MinVolume, playlist.MaxVolume, ts.Volume)
+	}
+	for i, sound := range ts.Sounds {
+		switch sound {
+		case "random", "same":
+		default:
+			if _, err := strconv.Atoi(sound); err != nil {
+				return fmt.Errorf("trigger '%s' sound %d has unknown sound choice '%s'", trigger, i, sound)
+			}
+		}
+	}
+	return nil
+}
+
+func defaultAudiobaitConfig() audiobaitConfig {
+	return audiobaitConfig{
+		ScheduleSources: []string{"api", "removable"},
+		ScheduleDir:     "/etc/cacophony/audiobait",
+		MediaDirs:       []string{"/media", "/run/media", "/mnt"},
+		TriggerSignals:  []string{"org.cacophony.thermalrecorder"},
+		Backend:         backendSox,
+		OutputDevice:    "default",
+		TargetLoudness:  -20,
+	}
+}
+
+func ParseConfig(configDir string) (*Config, error) {
 	configRW, err := goconfig.New(configDir)
 	if err != nil {
 		return nil, err
@@ -33,5 +146,31 @@ func ParseConfig(configDir string) (*goconfig.Audio, error) {
 		return nil, err
 	}
 
-	return &audio, nil
+	location := goconfig.DefaultWindowLocation()
+	if err := configRW.Unmarshal(goconfig.LocationKey, &location); err != nil {
+		return nil, err
+	}
+
+	audiobait := defaultAudiobaitConfig()
+	if err := configRW.Unmarshal(audiobaitKey, &audiobait); err != nil {
+		return nil, err
+	}
+	audiobait.setDefaultPaths(audio.Dir)
+	if err := audiobait.Calibration.validate(); err != nil {
+		return nil, err
+	}
+	for trigger, sounds := range audiobait.Triggers {
+		if err := sounds.validate(trigger); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Config{
+		Audio:           audio,
+		audiobaitConfig: audiobait,
+		Location: playlist.Location{
+			Latitude:  float64(location.Latitude),
+			Longitude: float64(location.Longitude),
+		},
+	}, nil
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTriggerSounds(t *testing.T) {
+	assert.NoError(t, triggerSounds{Sounds: []string{"random", "same", "3"}, Volume: 10}.validate("person"))
+
+	assert.EqualError(t, triggerSounds{Volume: 5}.validate("person"), "trigger 'person' has no sounds")
+	assert.EqualError(t, triggerSounds{Sounds: []string{"3"}}.validate("person"),
+		"trigger 'person' volume must be between 1 and 10 (got 0)")
+	assert.EqualError(t, triggerSounds{Sounds: []string{"3"}, Volume: 11}.validate("person"),
+		"trigger 'person' volume must be between 1 and 10 (got 11)")
+	assert.EqualError(t, triggerSounds{Sounds: []string{"3", "loud"}, Volume: 5}.validate("person"),
+		"trigger 'person' sound 1 has unknown sound choice 'loud'")
+}
+
+func TestDefaultPathsAreInAudioDir(t *testing.T) {
+	conf := defaultAudiobaitConfig()
+	conf.setDefaultPaths("/data/audio")
+	assert.Equal(t, "/data/audio/mute.json", conf.MuteFile)
+	assert.Equal(t, "/data/audio/output", conf.OutputDir)
+
+	conf = audiobaitConfig{MuteFile: "/tmp/mute.json", OutputDir: "/tmp/output"}
+	conf.setDefaultPaths("/data/audio")
+	assert.Equal(t, "/tmp/mute.json", conf.MuteFile)
+	assert.Equal(t, "/tmp/output", conf.OutputDir)
+}
+
+func TestPreemptIsOffByDefault(t *testing.T) {
+	assert.False(t, defaultAudiobaitConfig().Preempt)
+}
@@ -25,6 +25,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/TheCacophonyProject/audiobait/v3/audiofilelibrary"
@@ -44,23 +45,39 @@ const (
 	downloadRetryInterval = 30 * time.Second
 )
 
-func NewDownloader(audioDir string) *Downloader {
+func NewDownloader(audioDir string, status *statusTracker, sources ...ScheduleSource) *Downloader {
 	dl := &Downloader{
 		audioDir: audioDir,
+		status:   status,
 		updated:  make(chan struct{}, 128),
 		stop:     make(chan struct{}),
 	}
-	go dl.loop()
+	for _, source := range sources {
+		go dl.loop(source)
+	}
 	return dl
 }
 
-// Downloader manages retrieving audio schedules and associated sound files from the API server.
+// Downloader manages retrieving audio schedules and associated sound files from the schedule sources.
 type Downloader struct {
 	audioDir string
+	status   *statusTracker
 	updated  chan struct{}
 	stop     chan struct{}
+	// inUse is the source the schedule in use came from, guarded by installMu.
+	inUse ScheduleSource
 }
 
+// installMu stops two sources changing the schedule or the audio files at the
+// same time. It is only held while files are put in place, not while they are
+// being fetched, so that a slow source doesn't hold up the others.
+var installMu sync.Mutex
+
+// filesChanged counts the audio files put in place or quarantined, so that the
+// player is reloaded when its files change even if the schedule doesn't. It is
+// guarded by installMu.
+var filesChanged int
+
 func (dl *Downloader) Updated() <-chan struct{} {
 	return dl.updated
 }
@@ -69,58 +86,140 @@ func (dl *Downloader) Stop() {
 	close(dl.stop)
 }
 
-func (dl *Downloader) loop() {
+func (dl *Downloader) loop(source ScheduleSource) {
 	// Always check for updates on starting
 	nextUpdate := time.After(0)
 
 	for {
 		select {
 		case <-nextUpdate:
-			if changed, err := dl.update(); err != nil {
-				log.Printf("schedule update failed: %v", err)
+			if changed, err := dl.update(source); err != nil {
+				log.Printf("schedule update from %s failed: %v", source.Name(), err)
 			} else if changed {
 				log.Printf("schedule changed")
 				dl.updated <- struct{}{}
 			}
-			// Randomise sleep time between 45 - 75 minutes in order to distribute load on API server
-			checkSleep := time.Duration((45 + rand.Intn(30))) * time.Minute
-			log.Printf("waiting for %s until next schedule check", checkSleep)
-			nextUpdate = time.After(checkSleep)
+			nextUpdate = time.After(source.NextCheck())
 		case <-dl.stop:
 			return
 		}
 	}
 }
 
-func (dl *Downloader) update() (bool, error) {
+// update fetches from the source and saves the schedule if it is new. It
+// returns true if the schedule or any of the audio files changed. The source
+// isn't checked while the schedule in use came from a source with a higher
+// rank that is still present.
+// The result is recorded in the status unless the source had nothing to fetch.
+func (dl *Downloader) update(source ScheduleSource) (bool, error) {
+	installMu.Lock()
+	changedBefore := filesChanged
+	inUse := dl.inUse
+	installMu.Unlock()
+	if inUse != nil && inUse.Rank() > source.Rank() && inUse.Present() {
+		log.Printf("not checking %s while the schedule from %s is in use", source.Name(), inUse.Name())
+		return false, nil
+	}
+
+	schedule, err := source.Fetch()
+	if err == nil && schedule == nil {
+		return false, nil
+	}
+	changed := false
+	if err == nil {
+		installMu.Lock()
+		measureLoudness(dl.audioDir, schedule)
+		changed, err = playlist.SaveScheduleIfNew(dl.audioDir, schedule)
+		if err == nil {
+			dl.use(source)
+		}
+		if filesChanged != changedBefore {
+			log.Print("audio files changed")
+			changed = true
+		}
+		installMu.Unlock()
+	}
+	dl.status.downloaded(source.Name(), changed, err)
+	return changed, err
+}
+
+// use records that the schedule in use came from the source. installMu must be held.
+func (dl *Downloader) use(source ScheduleSource) {
+	if dl.inUse != nil && dl.inUse != source {
+		dl.inUse.InUse(false)
+	}
+	dl.inUse = source
+	source.InUse(true)
+}
+
+// measureLoudness measures the loudness of the schedule's audio files that
+// haven't been measured yet.
+func measureLoudness(audioDir string, schedule *playlist.Schedule) {
+	library, err := openLibrary(audioDir)
+	if err == nil {
+		err = library.MeasureLoudness(schedule.GetReferencedSounds())
+	}
+	if err != nil {
+		log.Printf("could not measure loudness: %v", err)
+	}
+}
+
+// apiSource downloads schedules and their audio files from the API server.
+type apiSource struct {
+	audioDir string
+}
+
+func newAPISource(audioDir string) *apiSource {
+	return &apiSource{audioDir: audioDir}
+}
+
+func (s *apiSource) Name() string {
+	return apiSourceName
+}
+
+func (s *apiSource) NextCheck() time.Duration {
+	// Randomise sleep time between 45 - 75 minutes in order to distribute load on API server
+	checkSleep := time.Duration((45 + rand.Intn(30))) * time.Minute
+	log.Printf("waiting for %s until next schedule check", checkSleep)
+	return checkSleep
+}
+
+func (s *apiSource) Rank() int {
+	return apiSourceRank
+}
+
+// Present is always true as the server always has a schedule for the device.
+func (s *apiSource) Present() bool {
+	return true
+}
+
+func (s *apiSource) InUse(inUse bool) {}
+
+func (s *apiSource) Fetch() (*playlist.Schedule, error) {
 	log.Println("requesting internet connection")
 	connReq, err := connectToInternet()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	log.Println("internet connection made")
 	defer connReq.Stop()
 
 	api, err := initiateAPI()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	schedule, err := playlist.GetScheduleFromAPI(api)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	log.Println("schedule downloaded")
-	if err := dl.getFilesForSchedule(api, schedule); err != nil {
-		return false, err
-	}
 	log.Println("starting downloading audio files.")
-	if err := dl.getFilesForSchedule(api, schedule); err != nil {
-		return false, err
+	if err := s.getFilesForSchedule(api, schedule); err != nil {
+		return nil, err
 	}
 	log.Println("all audio files downloaded")
-
-	return playlist.SaveScheduleIfNew(dl.audioDir, schedule)
+	return schedule, nil
 }
 
 func connectToInternet() (*connrequester.ConnectionRequester, error) {
@@ -146,24 +245,24 @@ func initiateAPI() (*api.CacophonyAPI, error) {
 	return cacAPI, nil
 }
 
-func (dl *Downloader) getFilesForSchedule(api *api.CacophonyAPI, schedule *playlist.Schedule) error {
-	return dl.downloadAllNewFiles(api, schedule.GetReferencedSounds())
+func (s *apiSource) getFilesForSchedule(api *api.CacophonyAPI, schedule *playlist.Schedule) error {
+	return s.downloadAllNewFiles(api, schedule.GetReferencedSounds())
 }
 
-func (dl *Downloader) downloadAllNewFiles(api *api.CacophonyAPI, fileIDs []int) error {
+func (s *apiSource) downloadAllNewFiles(api *api.CacophonyAPI, fileIDs []int) error {
 	for _, fileID := range fileIDs {
-		fileResp, err := dl.getFileDetails(api, fileID)
+		fileResp, err := s.getFileDetails(api, fileID)
 		if err != nil {
 			return fmt.Errorf("error getting file details for file with ID %d. Error is %s", fileID, err)
 		}
-		if err := dl.downloadAudioFile(api, fileID, fileResp); err != nil {
+		if err := s.downloadAudioFile(api, fileID, fileResp); err != nil {
 			return fmt.Errorf("error downloading file %d: %v", fileID, err)
 		}
 	}
 	return nil
 }
 
-func (dl *Downloader) getFileDetails(apiObj *api.CacophonyAPI, fileID int) (*api.FileResponse, error) {
+func (s *apiSource) getFileDetails(apiObj *api.CacophonyAPI, fileID int) (*api.FileResponse, error) {
 	var fileResp *api.FileResponse
 	err := retry(
 		fmt.Sprintf("get details for file %d", fileID),
@@ -180,19 +279,31 @@ func (dl *Downloader) getFileDetails(apiObj *api.CacophonyAPI, fileID int) (*api
 }
 
 // Try and download a single audio file from the API server.
-func (dl *Downloader) downloadAudioFile(api *api.CacophonyAPI, fileID int, fileResp *api.FileResponse) error {
+func (s *apiSource) downloadAudioFile(api *api.CacophonyAPI, fileID int, fileResp *api.FileResponse) error {
 	filename := audiofilelibrary.MakeFileName(fileResp.File.Details.OriginalName, fileResp.File.Details.Name, fileID)
+	info := audiofilelibrary.FileInfo{
+		ID:           fileID,
+		Name:         fileResp.File.Details.Name,
+		OriginalName: fileResp.File.Details.OriginalName,
+	}
+	if s.haveFile(filename, info, fileResp.FileSize) {
+		return nil
+	}
 
-	return retry(
+	// The file is downloaded next to where it goes, without holding installMu,
+	// and then moved into place.
+	tmp := filepath.Join(s.audioDir, filename+".tmp")
+	err := retry(
 		fmt.Sprintf("download and validate file %d", fileID),
 		func() error {
-			// Note: DownloadFile will skip the download if the file already exists.
-			if err := api.DownloadFile(fileResp, filepath.Join(dl.audioDir, filename)); err != nil {
+			// DownloadFile skips the download if the file already exists.
+			os.Remove(tmp)
+			if err := api.DownloadFile(fileResp, tmp); err != nil {
 				return err
 			}
-			if !dl.validateSoundFile(filepath.Join(dl.audioDir, filename), fileResp.FileSize) {
+			if !s.validateSoundFile(tmp, fileResp.FileSize) {
 				log.Printf("%s is not valid. Removing from disk.", filename)
-				if err := os.Remove(filepath.Join(dl.audioDir, filename)); err != nil {
+				if err := os.Remove(tmp); err != nil {
 					return fmt.Errorf("could not remove file: %v", err)
 				}
 				return errors.New("download was not valid")
@@ -200,10 +311,101 @@ func (dl *Downloader) downloadAudioFile(api *api.CacophonyAPI, fileID int, fileR
 			return nil // File is valid
 		},
 	)
+	if err != nil {
+		return err
+	}
+
+	installMu.Lock()
+	defer installMu.Unlock()
+	if err := os.Rename(tmp, filepath.Join(s.audioDir, filename)); err != nil {
+		return err
+	}
+	filesChanged++
+	info.Downloaded = now()
+	indexFile(s.audioDir, info, true)
+	return nil
+}
+
+// haveFile returns true if a valid copy of an audio file is already in the
+// library, adding it to the index if it isn't there yet. An invalid copy is
+// quarantined so that it is downloaded again.
+func (s *apiSource) haveFile(filename string, info audiofilelibrary.FileInfo, expectedSize int) bool {
+	installMu.Lock()
+	defer installMu.Unlock()
+	library, err := openLibrary(s.audioDir)
+	if err != nil {
+		log.Printf("could not open library to check %s: %v", filename, err)
+		return false
+	}
+	if existing, _ := library.GetFileNameOnDisk(info.ID); existing != filename {
+		return false
+	}
+	if err := s.checkExistingFile(library, info.ID, expectedSize); err != nil {
+		log.Printf("%s is not valid: %v", filename, err)
+		quarantine(library, info.ID)
+		return false
+	}
+	indexFile(s.audioDir, info, false)
+	return true
+}
+
+// indexFile adds an audio file to the library index. A file that is already in
+// the index is only updated if replace is true.
+func indexFile(audioDir string, info audiofilelibrary.FileInfo, replace bool) {
+	library, err := openLibrary(audioDir)
+	if err != nil {
+		log.Printf("could not open library to index file %d: %v", info.ID, err)
+		return
+	}
+	if _, indexed := library.FileInfo(info.ID); indexed && !replace {
+		return
+	}
+	if _, err := library.AddToIndex(info); err != nil {
+		log.Printf("could not add file %d to the library index: %v", info.ID, err)
+	}
+}
+
+// checkExistingFile checks that an audio file already in the library is the size
+// the API server says it should be and that its contents match the hash recorded
+// when it was downloaded. The API server doesn't give a hash for files. It
+// returns nil if the file isn't in the library.
+func (s *apiSource) checkExistingFile(library *audiofilelibrary.AudioFileLibrary, fileID, expectedSize int) error {
+	filename, exists := library.GetFileNameOnDisk(fileID)
+	if !exists {
+		return nil
+	}
+	if !s.validateSoundFile(filepath.Join(s.audioDir, filename), expectedSize) {
+		return errors.New("wrong size")
+	}
+	return library.Verify(fileID)
+}
+
+// quarantine moves an audio file out of the library so that it is fetched again.
+// installMu must be held.
+func quarantine(library *audiofilelibrary.AudioFileLibrary, fileID int) {
+	if err := library.Quarantine(fileID); err != nil {
+		log.Printf("could not quarantine file %d: %v", fileID, err)
+	} else {
+		log.Printf("quarantined file %d", fileID)
+		filesChanged++
+	}
+}
+
+// verifyLibrary checks the audio files against the hashes recorded when they
+// were downloaded, quarantining those that are corrupt so they are fetched again.
+func verifyLibrary(audioDir string) {
+	library, err := openLibrary(audioDir)
+	if err != nil {
+		log.Printf("could not verify audio files: %v", err)
+		return
+	}
+	if quarantined := library.VerifyAll(); len(quarantined) > 0 {
+		log.Printf("quarantined corrupt audio files %v", quarantined)
+	}
 }
 
-// Check that the sound file is valid.
-func (dl *Downloader) validateSoundFile(filename string, expectedSize int) bool {
+// Check that the sound file is the expected size.
+func (s *apiSource) validateSoundFile(filename string, expectedSize int) bool {
 	fileInfo, err := os.Stat(filename)
 	if err != nil {
 		return false
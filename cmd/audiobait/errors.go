@@ -0,0 +1,59 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"github.com/TheCacophonyProject/audiobait/v3/audiobaitclient"
+	"github.com/godbus/dbus"
+)
+
+// kindError gives an error one of the errors from audiobaitclient, which decides
+// the name of the D-Bus error it is returned as.
+type kindError struct {
+	kind error
+	err  error
+}
+
+// withKind makes errors.Is match err with kind, keeping err's message.
+func withKind(kind, err error) error {
+	return kindError{kind: kind, err: err}
+}
+
+func (e kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e kindError) Unwrap() error {
+	return e.err
+}
+
+// dbusErr turns an error into a D-Bus error named after its kind, see audiobaitclient.ErrorName.
+func dbusErr(err error) *dbus.Error {
+	if err == nil {
+		return nil
+	}
+	return &dbus.Error{
+		Name: audiobaitclient.ErrorName(err),
+		Body: []interface{}{err.Error()},
+	}
+}
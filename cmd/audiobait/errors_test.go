@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/TheCacophonyProject/audiobait/v3/audiobaitclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBusErrorNames(t *testing.T) {
+	err := dbusErr(withKind(audiobaitclient.ErrFileNotFound, errors.New("could not find file with ID 3")))
+	assert.Equal(t, "org.cacophony.Audiobait.Error.FileNotFound", err.Name)
+	assert.Equal(t, []interface{}{"could not find file with ID 3"}, err.Body)
+
+	err = dbusErr(fmt.Errorf("while playing: %w", audiobaitclient.ErrSoundCard))
+	assert.Equal(t, "org.cacophony.Audiobait.Error.SoundCard", err.Name)
+
+	err = dbusErr(errors.New("disk full"))
+	assert.Equal(t, "org.cacophony.Audiobait.Error.Failed", err.Name)
+
+	assert.Nil(t, dbusErr(nil))
+}
+
+func TestBadEventJSONIsInvalidEvent(t *testing.T) {
+	s := service{}
+	_, _, err := s.PlayFromIdWithReason(1, 2, 3, "{")
+	assert.Equal(t, "org.cacophony.Audiobait.Error.InvalidEvent", err.Name)
+}
@@ -19,10 +19,13 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	arg "github.com/alexflint/go-arg"
@@ -38,6 +41,8 @@ var version = "No version provided"
 type argSpec struct {
 	ConfigDir  string `arg:"-c,--config" help:"path to configuration directory"`
 	Timestamps bool   `arg:"-t,--timestamps" help:"include timestamps in log output"`
+	Plan       int    `arg:"--plan" help:"print what the schedule on disk will play over this many days and exit"`
+	Calendar   int    `arg:"--calendar" help:"print which of this many days are play and control nights and exit"`
 }
 
 func (argSpec) Version() string {
@@ -72,9 +77,32 @@ func runMain() error {
 		return err
 	}
 
+	if args.Plan > 0 {
+		return printPlan(conf, args.Plan)
+	}
+	if args.Calendar > 0 {
+		return printCalendar(conf, args.Calendar)
+	}
+
+	soundCard, err := newSoundCardPlayer(conf)
+	if err != nil {
+		return err
+	}
+	muter := newMuter(conf.MuteFile)
+	status := newStatusTracker(conf.Dir, muter)
 	if err := startService(player{
-		soundCard: NewSoundCardPlayer(conf.Card, conf.VolumeControl),
-		soundDir:  conf.Dir,
+		soundCard:         soundCard,
+		soundDir:          conf.Dir,
+		arbiter:           newArbiter(conf.Preempt),
+		muter:             muter,
+		status:            status,
+		calibration:       conf.Calibration,
+		normaliseLoudness: conf.NormaliseLoudness,
+		targetLoudness:    conf.TargetLoudness,
+		triggers:          conf.Triggers,
+		clock:             new(playlist.ActualClock),
+		location:          conf.Location,
+		cooldowns:         newCooldowns(),
 	}); err != nil {
 		return err
 	}
@@ -86,15 +114,58 @@ func runMain() error {
 		return err
 	}
 	log.Printf("Audio files directory is %s", conf.Dir)
+	verifyLibrary(conf.Dir)
 
 	// Start checking for new schedules
-	dl := NewDownloader(conf.Dir)
+	sources, err := NewScheduleSources(conf)
+	if err != nil {
+		return err
+	}
+	dl := NewDownloader(conf.Dir, status, sources...)
+	defer dl.Stop()
 
+	// Stop cleanly, between sounds, when asked to.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		log.Printf("received %v, stopping", sig)
+		cancel()
+	}()
+
+	triggers, err := listenForTriggers(conf.TriggerSignals)
+	if err != nil {
+		return err
+	}
+
+	playSchedules(ctx, new(playlist.ActualClock), conf, dl.Updated(), triggers, status)
+	return nil
+}
+
+// playSchedules plays the schedule from disk, reloading it whenever updated
+// signals a new one, until the context is cancelled. Combos played by a
+// trigger are played when it is sent on triggers. The schedule being played
+// is recorded in the status.
+func playSchedules(ctx context.Context, clock playlist.Clock, conf *Config, updated <-chan struct{}, triggers <-chan string, status *statusTracker) {
+	recorder := &AudioBaitEventRecorder{calibration: conf.Calibration}
+	var playTimer playlist.Timer
 	var playTime <-chan time.Time
+	var schedulePlayer *playlist.SchedulePlayer
+	var schedule *playlist.Schedule
 	for {
 		log.Print("loading schedule from disk")
-		schedulePlayer, schedule, err := createPlayer(conf.Dir)
-		if err != nil {
+		newPlayer, newSchedule, err := createPlayer(conf.Dir, clock, conf.Location, recorder)
+		if err == nil {
+			newPlayer.SetTriggers(triggers)
+			schedulePlayer, schedule = newPlayer, newSchedule
+			status.setSchedule(schedulePlayer, schedule)
+		} else if schedule != nil {
+			log.Printf("error creating player: %v (keeping last good schedule)", err)
+		}
+
+		if schedule == nil {
 			log.Printf("error creating player: %v (will wait for schedule update)", err)
 			playTime = nil
 		} else if len(schedule.Combos) < 1 {
@@ -103,19 +174,93 @@ func runMain() error {
 		} else {
 			playIn := schedulePlayer.TimeUntilNextCombo(*schedule)
 			log.Printf("waiting %s for schedule to start", playIn)
-			playTime = time.After(playIn)
+			playTimer = clock.NewTimer(playIn)
+			playTime = playTimer.C()
 		}
 
 		select {
-		case <-dl.Updated():
+		case <-ctx.Done():
+		case <-updated:
 			log.Print("new schedule - reloading")
 		case <-playTime:
 			log.Printf("Playing todays audiobait schedule...")
-			schedulePlayer.PlayTodaysSchedule(*schedule)
+			playTodaysSchedule(ctx, schedulePlayer, *schedule, updated)
+		}
+		if playTimer != nil {
+			playTimer.Stop()
+			playTimer = nil
+		}
+		if ctx.Err() != nil {
+			return
 		}
 	}
 }
 
+// playTodaysSchedule plays today's schedule until it finishes, the context is
+// cancelled or a new schedule arrives.
+func playTodaysSchedule(ctx context.Context, schedulePlayer *playlist.SchedulePlayer, schedule playlist.Schedule, updated <-chan struct{}) {
+	playCtx, cancelPlay := context.WithCancel(ctx)
+	defer cancelPlay()
+	done := make(chan error, 1)
+	go func() {
+		done <- schedulePlayer.PlayTodaysSchedule(playCtx, schedule)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Printf("stopped playing schedule: %v", err)
+		}
+	case <-updated:
+		log.Print("new schedule - stopping the current schedule after the current sound")
+		cancelPlay()
+		<-done
+	}
+}
+
+// printPlan prints every sound the schedule on disk will play over the next days.
+func printPlan(conf *Config, days int) error {
+	schedule, err := playlist.LoadScheduleFromDisk(conf.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read schedule from disk: %v", err)
+	}
+	files, err := getScheduleFiles(conf.Dir, schedule)
+	if err != nil {
+		return fmt.Errorf("problem collating files for schedule: %v", err)
+	}
+
+	now := time.Now()
+	for _, play := range playlist.Plan(*schedule, files, now, now.AddDate(0, 0, days), conf.Location) {
+		ts := play.Timestamp.Format("2006-01-02 15:04:05")
+		switch {
+		case play.Control:
+			fmt.Printf("%s control night\n", ts)
+		case play.FileID == 0:
+			fmt.Printf("%s combo %d: %s sound at volume %d\n", ts, play.Combo, play.Choice, play.Volume)
+		default:
+			fmt.Printf("%s combo %d: %s at volume %d\n", ts, play.Combo, files[play.FileID], play.Volume)
+		}
+	}
+	return nil
+}
+
+// printCalendar prints whether each of the next days is a play or a control night.
+func printCalendar(conf *Config, days int) error {
+	schedule, err := playlist.LoadScheduleFromDisk(conf.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read schedule from disk: %v", err)
+	}
+	for _, night := range playlist.CalendarFrom(*schedule, time.Now(), days, conf.Location) {
+		kind := "control"
+		if night.Play {
+			kind = "play"
+		}
+		fmt.Printf("%s %s night (%s design, block %d, day %d of the cycle)\n",
+			night.Date.Format("2006-01-02"), kind, night.Design, night.Block, night.DayOfCycle)
+	}
+	return nil
+}
+
 func createAudioPath(audioPath string) error {
 	err := os.MkdirAll(audioPath, 0755)
 	if err != nil {
@@ -124,7 +269,7 @@ func createAudioPath(audioPath string) error {
 	return nil
 }
 
-func createPlayer(audioDirectory string) (*playlist.SchedulePlayer, *playlist.Schedule, error) {
+func createPlayer(audioDirectory string, clock playlist.Clock, location playlist.Location, recorder playlist.EventRecorder) (*playlist.SchedulePlayer, *playlist.Schedule, error) {
 	schedule, err := playlist.LoadScheduleFromDisk(audioDirectory)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to read schedule from disk: %v", err)
@@ -136,7 +281,9 @@ func createPlayer(audioDirectory string) (*playlist.SchedulePlayer, *playlist.Sc
 	}
 
 	player := playlist.NewPlayer(files, audioDirectory)
-	player.SetRecorder(AudioBaitEventRecorder{})
+	player.SetClock(clock)
+	player.SetRecorder(recorder)
+	player.SetLocation(location)
 
 	return player, schedule, nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/audiobait/v3/playlist"
+	"github.com/TheCacophonyProject/event-reporter/eventclient"
+	goconfig "github.com/TheCacophonyProject/go-config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeControlSchedule writes a schedule that never plays sounds, so that the
+// main loop can be run without a sound card.
+func writeControlSchedule(t *testing.T, dir, description, dayChangeover string) {
+	schedule := playlist.Schedule{
+		Description:   description,
+		DayChangeover: *playlist.NewTimeOfDay(dayChangeover),
+		ControlNights: 1,
+		CycleStart:    playlist.NewDate(2019, time.January, 1),
+		AllSounds:     []int{1},
+		Combos: []playlist.Combo{{
+			From:    *playlist.NewTimeOfDay("19:00"),
+			Until:   *playlist.NewTimeOfDay("20:00"),
+			Every:   600,
+			Waits:   []int{0},
+			Volumes: []int{5},
+			Sounds:  []string{"1"},
+		}},
+	}
+	data, err := json.Marshal(&schedule)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, playlist.ScheduleFilename), data, 0644))
+}
+
+func TestScheduleUpdatedWhileWaiting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiobait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "beep-1.mp3"), nil, 0644))
+	writeControlSchedule(t, dir, "A", "12:00")
+
+	events := make(chan eventclient.Event, 10)
+	saveEvent = func(e eventclient.Event) error {
+		events <- e
+		return nil
+	}
+
+	clock := playlist.NewFakeClock(time.Date(2019, time.January, 1, 13, 0, 0, 0, time.UTC))
+	conf := &Config{Audio: goconfig.Audio{Dir: dir}}
+	updated := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		playSchedules(ctx, clock, conf, updated, nil, nil)
+		close(done)
+	}()
+
+	// Schedule A is waiting for the next day to start at midday when B
+	// arrives, which changes days at 3pm.
+	clock.BlockUntil(1)
+	writeControlSchedule(t, dir, "B", "15:00")
+	updated <- struct{}{}
+
+	bDayStart := time.Date(2019, time.January, 1, 15, 0, 0, 0, time.UTC)
+	clock.BlockUntilDeadline(bDayStart)
+	assert.Equal(t, 1, clock.Waiters())
+	clock.AdvanceToNext()
+
+	event := <-events
+	cancel()
+	<-done
+
+	assert.Equal(t, "audioBaitControlNight", event.Type)
+	assert.Equal(t, "B", event.Details["schedule"])
+	assert.Equal(t, "2019-01-01", event.Details["date"])
+	assert.Equal(t, bDayStart, event.Timestamp)
+}
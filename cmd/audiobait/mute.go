@@ -0,0 +1,125 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const reasonMuted = "muted"
+
+// muteState is what is saved to the mute file.
+type muteState struct {
+	// Priority is the highest priority that is muted.
+	Priority int `json:"priority"`
+	// Until is when the mute ends. A zero time means it lasts until unmuted.
+	Until time.Time `json:"until,omitempty"`
+}
+
+// muter stops sounds with a priority at or below a threshold from being played
+// for a while. The mute is saved to a file so it lasts over a restart.
+type muter struct {
+	path string
+
+	mu    sync.Mutex
+	state *muteState
+}
+
+// newMuter creates a muter, loading the mute from the file if there is one.
+func newMuter(path string) *muter {
+	m := &muter{path: path}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m
+	} else if err != nil {
+		log.Printf("could not read mute file: %v", err)
+		return m
+	}
+	var state muteState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		log.Printf("could not parse mute file: %v", err)
+		return m
+	}
+	m.state = &state
+	return m
+}
+
+// mute mutes sounds up to and including the priority for the duration, or until
+// unmuted if the duration isn't positive. It replaces any mute already in place.
+func (m *muter) mute(priority int, duration time.Duration) error {
+	state := &muteState{Priority: priority}
+	if duration > 0 {
+		state.Until = now().Add(duration)
+	}
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.path, raw, 0644); err != nil {
+		return err
+	}
+	m.state = state
+	return nil
+}
+
+// unmute removes the mute.
+func (m *muter) unmute() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = nil
+	if err := os.Remove(m.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// muted checks if a sound with the priority is muted. A nil muter never mutes.
+func (m *muter) muted(priority int) bool {
+	state := m.current()
+	return state != nil && priority <= state.Priority
+}
+
+// current returns the mute in place, or nil if there isn't one.
+func (m *muter) current() *muteState {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == nil {
+		return nil
+	}
+	if !m.state.Until.IsZero() && !now().Before(m.state.Until) {
+		return nil
+	}
+	state := *m.state
+	return &state
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/event-reporter/eventclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempMuteFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "audiobait-test")
+	require.NoError(t, err)
+	return filepath.Join(dir, "state", "mute.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestMuteThresholdAndExpiry(t *testing.T) {
+	newFakeNow()
+	path, cleanup := tempMuteFile(t)
+	defer cleanup()
+
+	m := newMuter(path)
+	assert.False(t, m.muted(1))
+
+	require.NoError(t, m.mute(2, time.Minute))
+	assert.True(t, m.muted(1))
+	assert.True(t, m.muted(2))
+	assert.False(t, m.muted(3))
+
+	start := now()
+	now = func() time.Time { return start.Add(time.Minute) }
+	assert.False(t, m.muted(1))
+}
+
+func TestMuteWithoutDurationLastsUntilUnmuted(t *testing.T) {
+	newFakeNow()
+	path, cleanup := tempMuteFile(t)
+	defer cleanup()
+
+	m := newMuter(path)
+	require.NoError(t, m.mute(2, 0))
+	start := now()
+	now = func() time.Time { return start.AddDate(1, 0, 0) }
+	assert.True(t, m.muted(1))
+
+	require.NoError(t, m.unmute())
+	assert.False(t, m.muted(1))
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, m.unmute())
+}
+
+func TestMuteLastsOverRestart(t *testing.T) {
+	newFakeNow()
+	path, cleanup := tempMuteFile(t)
+	defer cleanup()
+
+	require.NoError(t, newMuter(path).mute(2, time.Hour))
+	m := newMuter(path)
+	assert.True(t, m.muted(2))
+	assert.False(t, m.muted(3))
+}
+
+func TestMutedSoundIsRecorded(t *testing.T) {
+	newFakeNow()
+	path, cleanup := tempMuteFile(t)
+	defer cleanup()
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+	event := mockSaveEvent(nil)
+	soundCard := &recordingSoundCard{}
+	testPlayer := player{
+		soundCard: soundCard,
+		arbiter:   newArbiter(true),
+		muter:     newMuter(path),
+	}
+	require.NoError(t, testPlayer.muter.mute(3, time.Hour))
+
+	played, reason, err := testPlayer.PlayFromId(1, 2, 3, &eventclient.Event{
+		Type:    "doorbell",
+		Details: map[string]interface{}{"door": "front"},
+	})
+	assert.NoError(t, err)
+	assert.False(t, played)
+	assert.Equal(t, reasonMuted, reason)
+	assert.Empty(t, soundCard.played)
+	assert.Equal(t, eventclient.Event{
+		Type:      "audioBaitMuted",
+		Timestamp: now(),
+		Details: map[string]interface{}{
+			"calibration": "none",
+			"type":        "doorbell",
+			"door":        "front",
+			"fileId":      1,
+			"priority":    3,
+			"volume":      2,
+		},
+	}, **event)
+
+	// A muted event that can't be saved is an error.
+	mockSaveEvent(errors.New("disk full"))
+	played, reason, err = testPlayer.PlayFromId(1, 2, 3, nil)
+	assert.EqualError(t, err, "disk full")
+	assert.False(t, played)
+	assert.Equal(t, reasonMuted, reason)
+
+	played, _, err = testPlayer.PlayFromId(1, 2, 4, nil)
+	assert.NoError(t, err)
+	assert.True(t, played)
+	assert.Equal(t, []string{"/a@2"}, soundCard.played)
+}
+
+func TestMutedPlayFromIdIsMutedError(t *testing.T) {
+	newFakeNow()
+	path, cleanup := tempMuteFile(t)
+	defer cleanup()
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+	mockSaveEvent(nil)
+	s := service{player: player{
+		soundCard: &recordingSoundCard{},
+		arbiter:   newArbiter(true),
+		muter:     newMuter(path),
+	}}
+	require.NoError(t, s.player.muter.mute(3, time.Hour))
+
+	played, reason, err := s.PlayFromIdWithReason(1, 2, 3, "")
+	assert.Nil(t, err)
+	assert.False(t, played)
+	assert.Equal(t, reasonMuted, reason)
+
+	played, err = s.PlayFromId(1, 2, 3, "")
+	assert.False(t, played)
+	assert.Equal(t, "org.cacophony.Audiobait.Error.Muted", err.Name)
+}
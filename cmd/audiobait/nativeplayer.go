@@ -0,0 +1,93 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+
+	"github.com/TheCacophonyProject/audiobait/v3/audio"
+)
+
+// samplesPerWrite is how many samples are decoded and written to the output at
+// a time. Stopping a sound takes effect between writes.
+const samplesPerWrite = 4096
+
+// nativePlayer is a SoundCardPlayer that decodes audio files itself and writes
+// the samples to an audio.Output. The volume is applied to the samples using the
+// calibration, so the sound card's mixer is left as it is.
+type nativePlayer struct {
+	output      audio.Output
+	calibration calibration
+	stopper
+}
+
+// Play plays an audio file.
+func (p *nativePlayer) Play(ctx context.Context, audioFileName string, volume int, gainDB float64) error {
+	return p.run(ctx, func(ctx context.Context) error {
+		return playNative(ctx, p.output, audioFileName, p.calibration.amplitude(volume)*math.Pow(10, gainDB/20))
+	})
+}
+
+func playNative(ctx context.Context, output audio.Output, audioFileName string, gain float64) error {
+	f, err := audio.OpenFile(audioFileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := output.Open(ctx, f.Format())
+	if err != nil {
+		return err
+	}
+	samples := make([]int16, samplesPerWrite)
+	for ctx.Err() == nil {
+		n, err := f.ReadSamples(samples)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.Close()
+			return err
+		}
+		applyGain(samples[:n], gain)
+		if _, err := w.Write(audio.SamplesToBytes(samples[:n])); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// applyGain scales samples by gain, clipping those that go out of range.
+func applyGain(samples []int16, gain float64) {
+	for i, s := range samples {
+		v := math.Round(float64(s) * gain)
+		if v > math.MaxInt16 {
+			v = math.MaxInt16
+		} else if v < math.MinInt16 {
+			v = math.MinInt16
+		}
+		samples[i] = int16(v)
+	}
+}
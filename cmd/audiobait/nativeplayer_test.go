@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TheCacophonyProject/audiobait/v3/audio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestWAV(t *testing.T, dir string, samples []int16) string {
+	path := filepath.Join(dir, "in.wav")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, audio.WriteWAV(f, audio.Format{SampleRate: 8000, Channels: 1}, samples))
+	return path
+}
+
+func TestNativePlayerAppliesVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiobait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	in := writeTestWAV(t, dir, []int16{100, -100, 32767})
+	outDir := filepath.Join(dir, "out")
+	require.NoError(t, os.Mkdir(outDir, 0755))
+
+	p := &nativePlayer{output: &audio.FileOutput{Dir: outDir}}
+	require.NoError(t, p.Play(context.Background(), in, 5, 0))
+	assert.False(t, p.Stop())
+
+	f, err := audio.OpenFile(filepath.Join(outDir, "sound-1.wav"))
+	require.NoError(t, err)
+	defer f.Close()
+	samples := make([]int16, 10)
+	n, err := f.ReadSamples(samples)
+	require.NoError(t, err)
+	assert.Equal(t, []int16{50, -50, 16384}, samples[:n])
+}
+
+func TestApplyGainClips(t *testing.T) {
+	samples := []int16{20000, -20000, 100}
+	applyGain(samples, 2)
+	assert.Equal(t, []int16{32767, -32768, 200}, samples)
+}
+
+// blockingOutput blocks writes until the sound is cancelled.
+type blockingOutput struct {
+	writing chan struct{}
+}
+
+func (o blockingOutput) Open(ctx context.Context, format audio.Format) (io.WriteCloser, error) {
+	return blockingWriter{ctx: ctx, writing: o.writing}, nil
+}
+
+type blockingWriter struct {
+	ctx     context.Context
+	writing chan struct{}
+}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	w.writing <- struct{}{}
+	<-w.ctx.Done()
+	return 0, w.ctx.Err()
+}
+
+func (w blockingWriter) Close() error {
+	return nil
+}
+
+func TestNativePlayerStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiobait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	in := writeTestWAV(t, dir, []int16{1, 2, 3})
+
+	p := &nativePlayer{output: blockingOutput{writing: make(chan struct{})}}
+	done := make(chan error)
+	go func() { done <- p.Play(context.Background(), in, 10, 0) }()
+	<-p.output.(blockingOutput).writing
+	assert.True(t, p.Stop())
+	assert.Equal(t, errStopped, <-done)
+}
@@ -19,42 +19,144 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"os"
 	"os/exec"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/TheCacophonyProject/audiobait/v3/audio"
+	"github.com/TheCacophonyProject/audiobait/v3/audiobaitclient"
 	"github.com/TheCacophonyProject/audiobait/v3/audiofilelibrary"
+	"github.com/TheCacophonyProject/audiobait/v3/playlist"
 	"github.com/TheCacophonyProject/event-reporter/eventclient"
 )
 
 const (
 	testSound = "/var/lib/audiobait/testSound.wav"
+	// testSoundPriority is lower than the priority of any other sound.
+	testSoundPriority = 0
+	// reasonStopped is given for a sound that was cut short by Stop.
+	reasonStopped = "stopped"
+	// maxLoudnessBoost is the most, in dB, that a quiet file is made louder by
+	// loudness normalisation, so that noise in a nearly silent file isn't blown up.
+	maxLoudnessBoost = 12
 )
 
+// errStopped is returned by SoundCardPlayer.Play when the sound is stopped.
+var errStopped = errors.New("sound stopped")
+
 type player struct {
 	soundCard SoundCardPlayer
 	soundDir  string
+	arbiter   *arbiter
+	muter     *muter
+	status    *statusTracker
+	// calibration is recorded in the events of the sounds played.
+	calibration calibration
+	// normaliseLoudness corrects the gain of each file so it has targetLoudness.
+	normaliseLoudness bool
+	targetLoudness    float64
+	// emit sends a D-Bus signal. It is set once the service has started.
+	emit func(name string, args ...interface{})
+	// triggers are the sounds to play for triggers that aren't in the schedule.
+	triggers map[string]triggerSounds
+	// clock and location are used to check that a combo in the schedule can
+	// be played by a trigger now.
+	clock    playlist.Clock
+	location playlist.Location
+	// cooldowns stop a combo played by a trigger being played again too soon.
+	cooldowns *cooldowns
 }
 
 // Can be mocked for testing
 var saveEvent = eventclient.AddEvent
 var openLibrary = audiofilelibrary.OpenLibrary
+var loadSchedule = playlist.LoadScheduleFromDisk
 var now = time.Now
+var sleep = time.Sleep
+
+// PlayFromId plays an audio file once the arbiter allows a sound with the priority
+// to be played. If it isn't played the reason is returned. Muted sounds are recorded
+// with an audioBaitMuted event instead of being played, with reasonMuted given.
+// A sound that is cut short by
+// Stop counts as played, with reasonStopped given and recorded in its event.
+// The source of the sound is taken from the event's "source" detail, and is a
+// D-Bus client if it isn't the schedule or a trigger.
+func (p *player) PlayFromId(fileId, volume, priority int, event *eventclient.Event) (bool, string, error) {
+	return p.playFrom(sourceOf(event), fileId, volume, priority, event)
+}
 
-func (p *player) PlayFromId(fileId, volume, priority int, event *eventclient.Event) (bool, error) {
+// sourceOf works out where a request to play a sound came from.
+func sourceOf(event *eventclient.Event) string {
+	if event != nil {
+		switch source := event.Details["source"]; source {
+		case audiobaitclient.SourceSchedule, audiobaitclient.SourceTrigger:
+			return source.(string)
+		}
+	}
+	return audiobaitclient.SourceClient
+}
+
+// playFrom is PlayFromId for a sound from the given source. The source is sent in
+// the PlaybackStarted and PlaybackFinished signals.
+func (p *player) playFrom(source string, fileId, volume, priority int, event *eventclient.Event) (bool, string, error) {
 	library, err := openLibrary(p.soundDir)
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 	fileName, found := library.FilesByID[fileId]
 	if !found {
-		return false, fmt.Errorf("could not find file with ID %d", fileId)
+		return false, "", withKind(audiobaitclient.ErrFileNotFound, fmt.Errorf("could not find file with ID %d", fileId))
+	}
+	if p.muter.muted(priority) {
+		log.Printf("not playing '%s': %s", fileName, reasonMuted)
+		return false, reasonMuted, p.saveMutedEvent(fileId, volume, priority, event)
+	}
+	ctx, release, reason := p.arbiter.acquire(priority)
+	if reason != "" {
+		log.Printf("not playing '%s': %s", fileName, reason)
+		return false, reason, nil
+	}
+	defer release()
+	correction, corrected := p.loudnessCorrection(library, fileId)
+	path := p.soundDir + "/" + fileName
+	info, indexed := library.FileInfo(fileId)
+	duration := info.Duration
+	if !indexed {
+		duration = soundDuration(path)
 	}
 	log.Printf("playing '%s' at volume %d\n", fileName, volume)
 	playTime := now()
-	if err := p.soundCard.Play(p.soundDir+"/"+fileName, volume); err != nil {
-		return false, err
+	p.status.startedPlaying(audiobaitclient.PlayingStatus{
+		FileID:   fileId,
+		FileName: fileName,
+		Volume:   volume,
+		Priority: priority,
+		Started:  playTime,
+		Duration: duration,
+	})
+	defer p.status.finishedPlaying()
+	p.signal(playbackStartedSignal, fileId, volume, priority, source, playTime.UnixNano(), int64(duration))
+	err = p.soundCard.Play(ctx, path, volume, correction)
+	endTime := now()
+	p.signal(playbackFinishedSignal, fileId, volume, priority, source, playTime.UnixNano(), int64(duration),
+		endTime.UnixNano(), int64(endTime.Sub(playTime)))
+	reason = ""
+	if err == errStopped {
+		log.Printf("'%s' was stopped after %s", fileName, endTime.Sub(playTime))
+		reason = reasonStopped
+	} else if err != nil {
+		if ctx.Err() != nil {
+			log.Printf("'%s' %s", fileName, reasonPreempted)
+			return false, reasonPreempted, nil
+		}
+		return false, "", withKind(audiobaitclient.ErrSoundCard, err)
 	}
 	if event != nil {
 		if event.Type == "" {
@@ -67,38 +169,320 @@ func (p *player) PlayFromId(fileId, volume, priority int, event *eventclient.Eve
 		event.Details["fileId"] = fileId
 		event.Details["volume"] = volume
 		event.Details["priority"] = priority
+		p.calibration.addDetails(event.Details, volume)
+		if corrected {
+			event.Details["loudnessCorrectionDb"] = correction
+		} else if p.normaliseLoudness {
+			event.Details["loudnessUnmeasured"] = true
+		}
+		if reason == reasonStopped {
+			event.Details["cutShort"] = true
+			event.Details["playedFor"] = endTime.Sub(playTime).Seconds()
+		}
 		log.Println("finished playing. saving event")
-		return true, saveEvent(*event)
+		return true, reason, saveEvent(*event)
 	}
 	log.Println("finished playing")
-	return true, nil
+	return true, reason, nil
+}
+
+// soundDuration gives how long an audio file plays for, or 0 if it can't be decoded.
+func soundDuration(path string) time.Duration {
+	probed, err := audio.Probe(path)
+	if err != nil {
+		return 0
+	}
+	return probed.Duration
+}
+
+// loudnessCorrection gives the gain in dB that brings an audio file to the target
+// loudness. It returns false if loudness isn't being normalised or the file
+// hasn't been measured, which is recorded in the event for the sound. Quiet
+// files are boosted by no more than maxLoudnessBoost.
+func (p *player) loudnessCorrection(library *audiofilelibrary.AudioFileLibrary, fileId int) (float64, bool) {
+	if !p.normaliseLoudness {
+		return 0, false
+	}
+	loudness, measured := library.Loudness(fileId)
+	if !measured {
+		log.Printf("not normalising file %d: its loudness hasn't been measured", fileId)
+		return 0, false
+	}
+	return math.Min(p.targetLoudness-loudness, maxLoudnessBoost), true
 }
 
+// saveMutedEvent records a sound that wasn't played because it was muted, along
+// with the details of the event that would have been recorded if it was played.
+func (p *player) saveMutedEvent(fileId, volume, priority int, event *eventclient.Event) error {
+	details := map[string]interface{}{}
+	if event != nil {
+		for k, v := range event.Details {
+			details[k] = v
+		}
+		if event.Type != "" {
+			details["type"] = event.Type
+		}
+	}
+	details["fileId"] = fileId
+	details["volume"] = volume
+	details["priority"] = priority
+	p.calibration.addDetails(details, volume)
+	return saveEvent(eventclient.Event{
+		Timestamp: now(),
+		Type:      "audioBaitMuted",
+		Details:   details,
+	})
+}
+
+// PlayTrigger plays the sounds for a trigger. The trigger is looked up in the
+// combos of the schedule on disk, then in the triggers from the config. The sounds
+// are played at the given volume, or the volumes from the combo if it is 0.
+// A combo in the schedule is only played on a play night, during its window
+// and once its Every has passed since the trigger last played it.
+// It returns whether any of the sounds were played, and if none were the reason.
+func (p *player) PlayTrigger(trigger string, volume, priority int, makeEvent bool) (bool, string, error) {
+	combo, sounds, reason, err := p.resolveTrigger(trigger)
+	if err != nil || reason != "" {
+		if reason != "" {
+			log.Printf("not playing '%s' trigger: %s", trigger, reason)
+		}
+		return false, reason, err
+	}
+	chooser := playlist.NewSoundChooser(sounds)
+	anyPlayed := false
+	reason = "no sounds could be chosen"
+	for i, choice := range combo.Sounds {
+		sleep(time.Duration(combo.Waits[i]) * time.Second)
+		fileID, _ := chooser.ChooseSound(choice)
+		if fileID == 0 {
+			log.Printf("could not choose a sound for '%s'", choice)
+			continue
+		}
+		soundVolume := volume
+		if soundVolume == 0 {
+			soundVolume = combo.Volumes[i]
+		}
+		var event *eventclient.Event
+		if makeEvent {
+			event = &eventclient.Event{
+				Details: map[string]interface{}{"trigger": trigger},
+			}
+		}
+		played, notPlayed, err := p.playFrom(audiobaitclient.SourceTrigger, fileID, soundVolume, priority, event)
+		if err != nil {
+			return anyPlayed, "", err
+		}
+		if !played {
+			reason = notPlayed
+		}
+		anyPlayed = anyPlayed || played
+	}
+	if anyPlayed {
+		return true, "", nil
+	}
+	return false, reason, nil
+}
+
+// resolveTrigger finds the sounds to play for a trigger, and the audio files
+// they can be chosen from. If the trigger's combo can't be played now the
+// reason is returned instead.
+func (p *player) resolveTrigger(trigger string) (playlist.Combo, map[int]string, string, error) {
+	library, err := openLibrary(p.soundDir)
+	if err != nil {
+		return playlist.Combo{}, nil, "", err
+	}
+
+	if schedule, err := loadSchedule(p.soundDir); err == nil {
+		for i, combo := range schedule.Combos {
+			if combo.Trigger != trigger {
+				continue
+			}
+			if reason := p.triggeredComboRefused(*schedule, i); reason != "" {
+				return playlist.Combo{}, nil, reason, nil
+			}
+			sounds := make(map[int]string)
+			for _, fileID := range schedule.GetReferencedSounds() {
+				if fileName, found := library.FilesByID[fileID]; found {
+					sounds[fileID] = fileName
+				}
+			}
+			return combo, sounds, "", nil
+		}
+	}
+
+	if ts, found := p.triggers[trigger]; found {
+		combo := playlist.Combo{Sounds: ts.Sounds}
+		for _, sound := range ts.Sounds {
+			if fileID, err := strconv.Atoi(sound); err == nil && library.FilesByID[fileID] == "" {
+				return playlist.Combo{}, nil, "", withKind(audiobaitclient.ErrFileNotFound, fmt.Errorf("trigger '%s' plays file %d which is not in the library", trigger, fileID))
+			}
+
+			combo.Waits = append(combo.Waits, 0)
+			combo.Volumes = append(combo.Volumes, ts.Volume)
+		}
+		return combo, library.FilesByID, "", nil
+	}
+	return playlist.Combo{}, nil, "", withKind(audiobaitclient.ErrUnknownTrigger, fmt.Errorf("unknown trigger '%s'", trigger))
+}
+
+// triggeredComboRefused checks that a combo in the schedule can be played by its
+// trigger now, returning the reason if it can't.
+func (p *player) triggeredComboRefused(schedule playlist.Schedule, index int) string {
+	clock := p.clock
+	if clock == nil {
+		clock = new(playlist.ActualClock)
+	}
+	schedulePlayer := playlist.NewPlayer(nil, p.soundDir)
+	schedulePlayer.SetClock(clock)
+	schedulePlayer.SetLocation(p.location)
+	if playable, reason := schedulePlayer.ComboPlayable(schedule, index); !playable {
+		return reason
+	}
+	combo := schedule.Combos[index]
+	return p.cooldowns.start(combo.Trigger, clock.Now(), time.Duration(combo.Every)*time.Second)
+}
+
+// PlayTestSound plays the test sound. It has the lowest priority so it never
+// gets in the way of other sounds.
 func (p *player) PlayTestSound(volume int) error {
-	return p.soundCard.Play(testSound, volume)
+	ctx, release, reason := p.arbiter.acquire(testSoundPriority)
+	if reason != "" {
+		return withKind(audiobaitclient.ErrBusy, fmt.Errorf("test sound not played: %s", reason))
+	}
+	defer release()
+	duration := soundDuration(testSound)
+	playTime := now()
+	p.status.startedPlaying(audiobaitclient.PlayingStatus{
+		FileName: testSound,
+		Volume:   volume,
+		Priority: testSoundPriority,
+		Started:  playTime,
+		Duration: duration,
+	})
+	defer p.status.finishedPlaying()
+	source := audiobaitclient.SourceClient
+	p.signal(playbackStartedSignal, 0, volume, testSoundPriority, source, playTime.UnixNano(), int64(duration))
+	err := p.soundCard.Play(ctx, testSound, volume, 0)
+	endTime := now()
+	p.signal(playbackFinishedSignal, 0, volume, testSoundPriority, source, playTime.UnixNano(), int64(duration),
+		endTime.UnixNano(), int64(endTime.Sub(playTime)))
+	if err == errStopped {
+		return nil
+	} else if err != nil {
+		return withKind(audiobaitclient.ErrSoundCard, err)
+	}
+	return nil
+}
+
+// Stop cuts short the sound that is playing. It returns false if no sound was playing.
+func (p *player) Stop() bool {
+	return p.soundCard.Stop()
+}
+
+// signal sends a D-Bus signal if the service has started.
+func (p *player) signal(name string, args ...interface{}) {
+	if p.emit != nil {
+		p.emit(name, args...)
+	}
 }
 
 type SoundCardPlayer interface {
-	Play(audioFileName string, volume int) error
+	// Play plays an audio file, stopping if the context is cancelled. gainDB is
+	// applied on top of the volume, such as to normalise the file's loudness.
+	// It returns errStopped if Stop is called while it is playing.
+	Play(ctx context.Context, audioFileName string, volume int, gainDB float64) error
+	// Stop stops the audio file that is playing. It returns false if nothing was playing.
+	Stop() bool
 }
 
 // NewSoundCardPlayer constructs a new sound card player variable.
-func NewSoundCardPlayer(aCard int, aControlName string) amixerPlayer {
-	return amixerPlayer{card: aCard, controlName: aControlName}
+func NewSoundCardPlayer(aCard int, aControlName string, aCalibration calibration) *amixerPlayer {
+	return &amixerPlayer{card: aCard, controlName: aControlName, calibration: aCalibration}
 }
 
-// amixerPlayer is a SoundCardPlayer that uses amixer.
+// newSoundCardPlayer makes the SoundCardPlayer for the configured backend.
+func newSoundCardPlayer(conf *Config) (SoundCardPlayer, error) {
+	switch conf.Backend {
+	case backendSox:
+		return NewSoundCardPlayer(conf.Card, conf.VolumeControl, conf.Calibration), nil
+	case backendNative:
+		return &nativePlayer{output: audio.AplayOutput{Device: conf.OutputDevice}, calibration: conf.Calibration}, nil
+	case backendFile:
+		if err := os.MkdirAll(conf.OutputDir, 0755); err != nil {
+			return nil, err
+		}
+		return &nativePlayer{output: &audio.FileOutput{Dir: conf.OutputDir}, calibration: conf.Calibration}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend '%s'", conf.Backend)
+	}
+}
+
+// amixerPlayer is a SoundCardPlayer that sets the volume with amixer and plays
+// sounds with sox's play. With a calibration profile the mixer is set to the
+// volume's gain in dB, otherwise to a percentage.
 type amixerPlayer struct {
 	card        int
 	controlName string
+	calibration calibration
+	stopper
 }
 
 // Play plays an audio file.
-func (p amixerPlayer) Play(audioFileName string, volume int) error {
+func (p *amixerPlayer) Play(ctx context.Context, audioFileName string, volume int, gainDB float64) error {
 	if err := p.setVolume(volume); err != nil {
 		return err
 	}
-	return p.play(audioFileName)
+	return p.run(ctx, func(ctx context.Context) error {
+		return p.play(ctx, audioFileName, gainDB)
+	})
+}
+
+// stopper lets a SoundCardPlayer stop the sound it is playing.
+type stopper struct {
+	mu sync.Mutex
+	// playing is the sound that is playing, if any.
+	playing *stoppable
+}
+
+// stoppable is a sound being played by stopper.run.
+type stoppable struct {
+	cancel  context.CancelFunc
+	stopped bool
+}
+
+// run plays a sound with play, returning errStopped if Stop cut it short. A
+// sound that finished before Stop took effect is returned as played.
+func (s *stopper) run(ctx context.Context, play func(context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sound := &stoppable{cancel: cancel}
+	s.mu.Lock()
+	s.playing = sound
+	s.mu.Unlock()
+
+	err := play(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.playing == sound {
+		s.playing = nil
+	}
+	if sound.stopped && err != nil {
+		return errStopped
+	}
+	return err
+}
+
+// Stop stops the sound that is playing.
+func (s *stopper) Stop() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.playing == nil {
+		return false
+	}
+	s.playing.cancel()
+	s.playing.stopped = true
+	return true
 }
 
 func (p *amixerPlayer) setVolume(volume int) error {
@@ -107,7 +491,7 @@ func (p *amixerPlayer) setVolume(volume int) error {
 		"-c", fmt.Sprint(p.card),
 		"sset",
 		p.controlName,
-		fmt.Sprintf("%d%%", volume*10),
+		p.mixerLevel(volume),
 	)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
@@ -116,8 +500,20 @@ func (p *amixerPlayer) setVolume(volume int) error {
 	return nil
 }
 
-func (p *amixerPlayer) play(filename string) error {
-	cmd := exec.Command("play", "-q", filename)
+// mixerLevel gives the amixer setting for a volume.
+func (p *amixerPlayer) mixerLevel(volume int) string {
+	if db, ok := p.calibration.gainDB(volume); ok {
+		return fmt.Sprintf("%.2fdB", db)
+	}
+	return fmt.Sprintf("%d%%", volume*10)
+}
+
+func (p *amixerPlayer) play(ctx context.Context, filename string, gainDB float64) error {
+	args := []string{"-q", filename}
+	if gainDB != 0 {
+		args = append(args, "vol", fmt.Sprintf("%.2fdB", gainDB))
+	}
+	cmd := exec.CommandContext(ctx, "play", args...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("play failed: %v\noutput:\n%s", err, out)
@@ -1,24 +1,37 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/TheCacophonyProject/audiobait/v3/audio"
+	"github.com/TheCacophonyProject/audiobait/v3/audiobaitclient"
 	"github.com/TheCacophonyProject/audiobait/v3/audiofilelibrary"
+	"github.com/TheCacophonyProject/audiobait/v3/playlist"
 	"github.com/TheCacophonyProject/event-reporter/eventclient"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockSoundCard struct {
 	err error
 }
 
-func (msc mockSoundCard) Play(audioFileName string, volume int) error {
+func (msc mockSoundCard) Play(ctx context.Context, audioFileName string, volume int, gainDB float64) error {
 	return msc.err
 }
 
+func (msc mockSoundCard) Stop() bool {
+	return false
+}
+
 func newMockSoundCard(err error) mockSoundCard {
 	return mockSoundCard{
 		err: err,
@@ -49,23 +62,25 @@ func TestPlayFromId(t *testing.T) {
 	event := mockSaveEvent(nil)
 	testPlayer := player{
 		soundCard: newMockSoundCard(nil),
+		arbiter:   newArbiter(true),
 	}
-	played, err := testPlayer.PlayFromId(1, 2, 3, &eventclient.Event{})
+	played, _, err := testPlayer.PlayFromId(1, 2, 3, &eventclient.Event{})
 	assert.NoError(t, err)
 	assert.True(t, played)
 	assert.Equal(t, eventclient.Event{
 		Type:      "audioBait",
 		Timestamp: now(),
 		Details: map[string]interface{}{
-			"fileId":   1,
-			"priority": 3,
-			"volume":   2,
+			"calibration": uncalibrated,
+			"fileId":      1,
+			"priority":    3,
+			"volume":      2,
 		},
 	}, **event)
 
 	log.Println("testing played audio with no event")
 	event = mockSaveEvent(nil)
-	played, err = testPlayer.PlayFromId(1, 2, 3, nil)
+	played, _, err = testPlayer.PlayFromId(1, 2, 3, nil)
 	assert.NoError(t, err)
 	assert.True(t, played)
 	var expectedEvent *eventclient.Event
@@ -74,24 +89,25 @@ func TestPlayFromId(t *testing.T) {
 	log.Println("testing failed library open")
 	libraryOpenFail := errors.New("failed to open library")
 	mockOpenLibrary(nil, libraryOpenFail)
-	played, err = testPlayer.PlayFromId(1, 2, 3, nil)
+	played, _, err = testPlayer.PlayFromId(1, 2, 3, nil)
 	assert.Equal(t, libraryOpenFail, err)
 	assert.False(t, played)
 	assert.Equal(t, expectedEvent, *event)
 
 	log.Println("testing failed to find file in library")
 	mockOpenLibrary(map[int]string{1: "a"}, nil)
-	played, err = testPlayer.PlayFromId(2, 3, 4, nil)
-	assert.Error(t, err)
+	played, _, err = testPlayer.PlayFromId(2, 3, 4, nil)
+	assert.True(t, errors.Is(err, audiobaitclient.ErrFileNotFound))
 	assert.False(t, played)
 	assert.Equal(t, expectedEvent, *event)
 
 	log.Println("testing failed to play audio")
 	soundcardError := errors.New("some soundcard error")
 	testPlayer.soundCard = newMockSoundCard(soundcardError)
-	played, err = testPlayer.PlayFromId(1, 2, 3, nil)
+	played, _, err = testPlayer.PlayFromId(1, 2, 3, nil)
 	assert.False(t, played)
-	assert.Equal(t, soundcardError, err)
+	assert.True(t, errors.Is(err, soundcardError))
+	assert.True(t, errors.Is(err, audiobaitclient.ErrSoundCard))
 	assert.Equal(t, expectedEvent, *event)
 }
 
@@ -101,3 +117,326 @@ func newFakeNow() {
 		return n
 	}
 }
+
+func mockLoadSchedule(schedule *playlist.Schedule, err error) {
+	loadSchedule = func(string) (*playlist.Schedule, error) {
+		return schedule, err
+	}
+}
+
+type recordingSoundCard struct {
+	played []string
+}
+
+func (sc *recordingSoundCard) Play(ctx context.Context, audioFileName string, volume int, gainDB float64) error {
+	sc.played = append(sc.played, fmt.Sprintf("%s@%d", audioFileName, volume))
+	return nil
+}
+
+func (sc *recordingSoundCard) Stop() bool {
+	return false
+}
+
+func TestPlayTriggerFromSchedule(t *testing.T) {
+	newFakeNow()
+	sleep = func(time.Duration) {}
+	mockOpenLibrary(map[int]string{1: "a", 2: "b"}, nil)
+	var events []eventclient.Event
+	saveEvent = func(e eventclient.Event) error {
+		events = append(events, e)
+		return nil
+	}
+	mockLoadSchedule(&playlist.Schedule{
+		AllSounds: []int{1, 2},
+		Combos: []playlist.Combo{
+			{From: *playlist.NewTimeOfDay("00:00"), Until: *playlist.NewTimeOfDay("23:59"), Sounds: []string{"1"}, Waits: []int{0}, Volumes: []int{3}},
+			{From: *playlist.NewTimeOfDay("00:00"), Until: *playlist.NewTimeOfDay("23:59"), Trigger: "Tracking", Sounds: []string{"2", "same"}, Waits: []int{0, 5}, Volumes: []int{4, 6}},
+		},
+	}, nil)
+	soundCard := &recordingSoundCard{}
+	testPlayer := player{
+		soundCard: soundCard,
+		soundDir:  "dir",
+		arbiter:   newArbiter(true),
+		clock:     playlist.NewFakeClock(time.Date(2021, time.April, 2, 19, 0, 0, 0, time.UTC)),
+	}
+
+	played, _, err := testPlayer.PlayTrigger("Tracking", 0, 1, true)
+	assert.NoError(t, err)
+	assert.True(t, played)
+	assert.Equal(t, []string{"dir/b@4", "dir/b@6"}, soundCard.played)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "Tracking", events[0].Details["trigger"])
+	assert.Equal(t, 2, events[0].Details["fileId"])
+
+	soundCard.played = nil
+	played, _, err = testPlayer.PlayTrigger("Tracking", 9, 1, false)
+	assert.NoError(t, err)
+	assert.True(t, played)
+	assert.Equal(t, []string{"dir/b@9", "dir/b@9"}, soundCard.played)
+	assert.Len(t, events, 2)
+}
+
+func TestScheduleTriggerOnlyPlaysWhenComboCan(t *testing.T) {
+	newFakeNow()
+	sleep = func(time.Duration) {}
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+	mockSaveEvent(nil)
+	schedule := &playlist.Schedule{
+		PlayNights:    1,
+		ControlNights: 1,
+		CycleStart:    playlist.NewDate(2021, time.April, 1),
+		AllSounds:     []int{1},
+		Combos: []playlist.Combo{{
+			From:    *playlist.NewTimeOfDay("19:00"),
+			Until:   *playlist.NewTimeOfDay("21:00"),
+			Every:   600,
+			Trigger: "Tracking",
+			Sounds:  []string{"1"},
+			Waits:   []int{0},
+			Volumes: []int{4},
+		}},
+	}
+	mockLoadSchedule(schedule, nil)
+	clock := playlist.NewFakeClock(time.Date(2021, time.April, 1, 19, 30, 0, 0, time.UTC))
+	soundCard := &recordingSoundCard{}
+	testPlayer := player{
+		soundCard: soundCard,
+		soundDir:  "dir",
+		arbiter:   newArbiter(true),
+		clock:     clock,
+		cooldowns: newCooldowns(),
+	}
+
+	played, reason, err := testPlayer.PlayTrigger("Tracking", 0, 1, false)
+	assert.NoError(t, err)
+	assert.True(t, played)
+	assert.Empty(t, reason)
+
+	// Not again until Every has passed.
+	clock.Advance(5 * time.Minute)
+	played, reason, err = testPlayer.PlayTrigger("Tracking", 0, 1, false)
+	assert.NoError(t, err)
+	assert.False(t, played)
+	assert.Equal(t, "'Tracking' trigger played 5m0s ago", reason)
+
+	clock.Advance(5 * time.Minute)
+	played, _, err = testPlayer.PlayTrigger("Tracking", 0, 1, false)
+	assert.NoError(t, err)
+	assert.True(t, played)
+
+	// Not outside the combo's window.
+	clock.Advance(2 * time.Hour)
+	played, reason, err = testPlayer.PlayTrigger("Tracking", 0, 1, false)
+	assert.NoError(t, err)
+	assert.False(t, played)
+	assert.Equal(t, "outside the combo's window", reason)
+
+	// Not on a control night.
+	clock.Advance(22 * time.Hour)
+	played, reason, err = testPlayer.PlayTrigger("Tracking", 0, 1, false)
+	assert.NoError(t, err)
+	assert.False(t, played)
+	assert.Equal(t, "tonight is a control night", reason)
+	assert.Len(t, soundCard.played, 2)
+}
+
+func TestPlayTriggerFromConfig(t *testing.T) {
+	sleep = func(time.Duration) {}
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+	mockLoadSchedule(nil, errors.New("no schedule"))
+	soundCard := &recordingSoundCard{}
+	testPlayer := player{
+		soundCard: soundCard,
+		soundDir:  "dir",
+		arbiter:   newArbiter(true),
+		triggers:  map[string]triggerSounds{"Tracking": {Sounds: []string{"random"}, Volume: 7}},
+	}
+
+	played, _, err := testPlayer.PlayTrigger("Tracking", 0, 1, false)
+	assert.NoError(t, err)
+	assert.True(t, played)
+	assert.Equal(t, []string{"dir/a@7"}, soundCard.played)
+
+	played, _, err = testPlayer.PlayTrigger("Unknown", 0, 1, false)
+	assert.Error(t, err)
+	assert.False(t, played)
+
+	testPlayer.triggers["person"] = triggerSounds{Sounds: []string{"2"}, Volume: 4}
+	played, _, err = testPlayer.PlayTrigger("person", 0, 1, false)
+	assert.True(t, errors.Is(err, audiobaitclient.ErrFileNotFound))
+	assert.False(t, played)
+}
+
+type emitted struct {
+	name string
+	args []interface{}
+}
+
+func TestPlaybackSignals(t *testing.T) {
+	newFakeNow()
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+	mockSaveEvent(nil)
+	mockLoadSchedule(nil, errors.New("no schedule"))
+	var signals []emitted
+	testPlayer := player{
+		soundCard: newMockSoundCard(nil),
+		arbiter:   newArbiter(true),
+		triggers:  map[string]triggerSounds{"person": {Sounds: []string{"1"}, Volume: 4}},
+		emit: func(name string, args ...interface{}) {
+			signals = append(signals, emitted{name, args})
+		},
+	}
+	ts := now().UnixNano()
+
+	_, _, err := testPlayer.PlayFromId(1, 2, 3, nil)
+	assert.NoError(t, err)
+	_, _, err = testPlayer.PlayFromId(1, 2, 1, &eventclient.Event{
+		Details: map[string]interface{}{"source": audiobaitclient.SourceSchedule},
+	})
+	assert.NoError(t, err)
+	_, _, err = testPlayer.PlayTrigger("person", 0, 5, false)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []emitted{
+		{playbackStartedSignal, []interface{}{1, 2, 3, audiobaitclient.SourceClient, ts, int64(0)}},
+		{playbackFinishedSignal, []interface{}{1, 2, 3, audiobaitclient.SourceClient, ts, int64(0), ts, int64(0)}},
+		{playbackStartedSignal, []interface{}{1, 2, 1, audiobaitclient.SourceSchedule, ts, int64(0)}},
+		{playbackFinishedSignal, []interface{}{1, 2, 1, audiobaitclient.SourceSchedule, ts, int64(0), ts, int64(0)}},
+		{playbackStartedSignal, []interface{}{1, 4, 5, audiobaitclient.SourceTrigger, ts, int64(0)}},
+		{playbackFinishedSignal, []interface{}{1, 4, 5, audiobaitclient.SourceTrigger, ts, int64(0), ts, int64(0)}},
+	}, signals)
+}
+
+func TestStoppedSoundIsRecordedAsCutShort(t *testing.T) {
+	start := time.Now()
+	now = func() time.Time { return start }
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+	event := mockSaveEvent(nil)
+	soundCard := newBlockingSoundCard()
+	testPlayer := player{soundCard: soundCard, arbiter: newArbiter(true)}
+
+	go func() {
+		<-soundCard.started
+		now = func() time.Time { return start.Add(1500 * time.Millisecond) }
+		testPlayer.Stop()
+	}()
+	played, reason, err := testPlayer.PlayFromId(1, 2, 3, &eventclient.Event{})
+	assert.NoError(t, err)
+	assert.True(t, played)
+	assert.Equal(t, reasonStopped, reason)
+	assert.Equal(t, eventclient.Event{
+		Type:      "audioBait",
+		Timestamp: start,
+		Details: map[string]interface{}{
+			"calibration": uncalibrated,
+			"fileId":      1,
+			"priority":    3,
+			"volume":      2,
+			"cutShort":    true,
+			"playedFor":   1.5,
+		},
+	}, **event)
+}
+
+// gainSoundCard records the gains sounds are played with.
+type gainSoundCard struct {
+	gains []float64
+}
+
+func (sc *gainSoundCard) Play(ctx context.Context, audioFileName string, volume int, gainDB float64) error {
+	sc.gains = append(sc.gains, gainDB)
+	return nil
+}
+
+func (sc *gainSoundCard) Stop() bool {
+	return false
+}
+
+func TestLoudnessNormalisation(t *testing.T) {
+	newFakeNow()
+	dir, err := ioutil.TempDir("", "audiobait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	samples := make([]int16, 100)
+	for i := range samples {
+		samples[i] = 16384
+	}
+	f, err := os.Create(filepath.Join(dir, "half-1.wav"))
+	require.NoError(t, err)
+	require.NoError(t, audio.WriteWAV(f, audio.Format{SampleRate: 8000, Channels: 1}, samples))
+	f.Close()
+	openLibrary = audiofilelibrary.OpenLibrary
+	measureLoudness(dir, &playlist.Schedule{Combos: []playlist.Combo{{Sounds: []string{"1"}}}})
+
+	event := mockSaveEvent(nil)
+	soundCard := &gainSoundCard{}
+	testPlayer := player{
+		soundCard:         soundCard,
+		soundDir:          dir,
+		arbiter:           newArbiter(true),
+		normaliseLoudness: true,
+		targetLoudness:    -20,
+	}
+	_, _, err = testPlayer.PlayFromId(1, 5, 1, &eventclient.Event{})
+	require.NoError(t, err)
+	require.Len(t, soundCard.gains, 1)
+	assert.InDelta(t, -13.98, soundCard.gains[0], 0.01)
+	assert.Equal(t, soundCard.gains[0], (*event).Details["loudnessCorrectionDb"])
+
+	testPlayer.targetLoudness = 20
+	_, _, err = testPlayer.PlayFromId(1, 5, 1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, float64(maxLoudnessBoost), soundCard.gains[1])
+
+	// Files that couldn't be measured are played without a correction.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "unknown-2.flac"), []byte("sound"), 0644))
+	_, _, err = testPlayer.PlayFromId(2, 5, 1, &eventclient.Event{})
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, soundCard.gains[2])
+	assert.Equal(t, true, (*event).Details["loudnessUnmeasured"])
+
+	testPlayer.normaliseLoudness = false
+	_, _, err = testPlayer.PlayFromId(1, 5, 1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, soundCard.gains[3])
+}
+
+func TestStopper(t *testing.T) {
+	var s stopper
+	assert.False(t, s.Stop())
+
+	// A sound that finishes even though it was stopped counts as played.
+	err := s.run(context.Background(), func(ctx context.Context) error {
+		assert.True(t, s.Stop())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, s.Stop())
+
+	// A sound finishing doesn't stop a later sound from being stopped.
+	firstPlaying, firstDone := make(chan struct{}), make(chan struct{})
+	first := make(chan error)
+	go func() {
+		first <- s.run(context.Background(), func(ctx context.Context) error {
+			close(firstPlaying)
+			<-firstDone
+			return nil
+		})
+	}()
+	<-firstPlaying
+	secondPlaying := make(chan struct{})
+	second := make(chan error)
+	go func() {
+		second <- s.run(context.Background(), func(ctx context.Context) error {
+			close(secondPlaying)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	<-secondPlaying
+	close(firstDone)
+	assert.NoError(t, <-first)
+	assert.True(t, s.Stop())
+	assert.Equal(t, errStopped, <-second)
+}
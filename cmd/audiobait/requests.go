@@ -0,0 +1,129 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/TheCacophonyProject/audiobait/v3/audiobaitclient"
+	"github.com/TheCacophonyProject/event-reporter/eventclient"
+)
+
+// keptResults is how many of the most recent requests' results are kept.
+const keptResults = 100
+
+// playResult is the outcome of a request to play a sound.
+type playResult struct {
+	ID     int
+	Played bool
+	Reason string
+	Error  string
+}
+
+// playRequests plays sounds in the background so the caller doesn't have to
+// wait. Requests are played one at a time in the order they were added, and
+// finished is called as each one completes.
+type playRequests struct {
+	player   *player
+	finished func(playResult)
+
+	mu     sync.Mutex
+	lastID int
+	// results holds the results of recent requests, nil while a request is still playing.
+	results map[int]*playResult
+	// queue holds the requests waiting to be played.
+	queue []playRequest
+	// playing is true while the worker is playing the queue.
+	playing bool
+}
+
+// playRequest is a queued request to play a sound.
+type playRequest struct {
+	id       int
+	fileId   int
+	volume   int
+	priority int
+	event    *eventclient.Event
+}
+
+func newPlayRequests(player *player, finished func(playResult)) *playRequests {
+	return &playRequests{
+		player:   player,
+		finished: finished,
+		results:  make(map[int]*playResult),
+	}
+}
+
+// add queues a sound to be played and returns the ID of the request.
+func (r *playRequests) add(fileId, volume, priority int, event *eventclient.Event) int {
+	r.mu.Lock()
+	r.lastID++
+	id := r.lastID
+	r.results[id] = nil
+	delete(r.results, id-keptResults)
+	r.queue = append(r.queue, playRequest{id: id, fileId: fileId, volume: volume, priority: priority, event: event})
+	if !r.playing {
+		r.playing = true
+		go r.play()
+	}
+	r.mu.Unlock()
+	return id
+}
+
+// play plays the queued requests in order until the queue is empty.
+func (r *playRequests) play() {
+	for {
+		r.mu.Lock()
+		if len(r.queue) == 0 {
+			r.playing = false
+			r.mu.Unlock()
+			return
+		}
+		req := r.queue[0]
+		r.queue = r.queue[1:]
+		r.mu.Unlock()
+
+		played, reason, err := r.player.PlayFromId(req.fileId, req.volume, req.priority, req.event)
+		result := playResult{ID: req.id, Played: played, Reason: reason}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		r.mu.Lock()
+		if _, ok := r.results[req.id]; ok {
+			r.results[req.id] = &result
+		}
+		r.mu.Unlock()
+		r.finished(result)
+	}
+}
+
+// result gets the result of a request, and whether it has finished.
+func (r *playRequests) result(id int) (playResult, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result, ok := r.results[id]
+	if !ok {
+		return playResult{}, false, withKind(audiobaitclient.ErrUnknownRequest, fmt.Errorf("unknown request %d", id))
+	}
+	if result == nil {
+		return playResult{ID: id}, false, nil
+	}
+	return *result, true, nil
+}
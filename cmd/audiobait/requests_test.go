@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlayRequestsFinish(t *testing.T) {
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+	testPlayer := &player{soundCard: newMockSoundCard(nil), arbiter: newArbiter(true)}
+	finished := make(chan playResult, 2)
+	requests := newPlayRequests(testPlayer, func(result playResult) {
+		finished <- result
+	})
+
+	id := requests.add(1, 2, 3, nil)
+	assert.Equal(t, playResult{ID: id, Played: true}, <-finished)
+	result, done, err := requests.result(id)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.True(t, result.Played)
+
+	missingID := requests.add(2, 2, 3, nil)
+	assert.NotEqual(t, id, missingID)
+	result = <-finished
+	assert.False(t, result.Played)
+	assert.Equal(t, "could not find file with ID 2", result.Error)
+
+	_, _, err = requests.result(missingID + 1)
+	assert.Error(t, err)
+}
+
+func TestOldPlayRequestsAreForgotten(t *testing.T) {
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+	testPlayer := &player{soundCard: newMockSoundCard(nil), arbiter: newArbiter(true)}
+	finished := make(chan playResult, keptResults+1)
+	requests := newPlayRequests(testPlayer, func(result playResult) {
+		finished <- result
+	})
+
+	first := requests.add(1, 2, 3, nil)
+	for i := 0; i < keptResults; i++ {
+		requests.add(1, 2, 3, nil)
+	}
+	for i := 0; i <= keptResults; i++ {
+		<-finished
+	}
+	_, _, err := requests.result(first)
+	assert.Error(t, err)
+	_, done, err := requests.result(first + 1)
+	assert.NoError(t, err)
+	assert.True(t, done)
+}
+
+// gatedSoundCard records the sounds played, waiting for the gate to open
+// before playing the first one.
+type gatedSoundCard struct {
+	recordingSoundCard
+	gate chan struct{}
+}
+
+func (sc *gatedSoundCard) Play(ctx context.Context, audioFileName string, volume int, gainDB float64) error {
+	<-sc.gate
+	return sc.recordingSoundCard.Play(ctx, audioFileName, volume, gainDB)
+}
+
+func TestPlayRequestsPlayInOrder(t *testing.T) {
+	mockOpenLibrary(map[int]string{1: "a", 2: "b", 3: "c"}, nil)
+	soundCard := &gatedSoundCard{gate: make(chan struct{})}
+	testPlayer := &player{soundCard: soundCard, arbiter: newArbiter(true)}
+	finished := make(chan playResult, 3)
+	requests := newPlayRequests(testPlayer, func(result playResult) {
+		finished <- result
+	})
+
+	first := requests.add(1, 2, 3, nil)
+	requests.add(2, 2, 3, nil)
+	requests.add(3, 2, 3, nil)
+	close(soundCard.gate)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, first+i, (<-finished).ID)
+	}
+	assert.Equal(t, []string{"/a@2", "/b@2", "/c@2"}, soundCard.played)
+}
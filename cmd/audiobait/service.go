@@ -21,10 +21,10 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"runtime"
-	"strings"
-	"sync"
+	"log"
+	"time"
 
+	"github.com/TheCacophonyProject/audiobait/v3/audiobaitclient"
 	"github.com/TheCacophonyProject/event-reporter/eventclient"
 	"github.com/godbus/dbus"
 	"github.com/godbus/dbus/introspect"
@@ -35,10 +35,18 @@ const (
 	dbusPath = "/org/cacophony/Audiobait"
 )
 
-var mu = sync.RWMutex{}
+const (
+	// playFinishedSignal is emitted when a sound requested with PlayFromIdAsync has finished.
+	playFinishedSignal = dbusName + ".PlayFinished"
+	// playbackStartedSignal and playbackFinishedSignal are emitted when any sound starts and
+	// stops playing, so other services can tell when a sound could be heard.
+	playbackStartedSignal  = dbusName + ".PlaybackStarted"
+	playbackFinishedSignal = dbusName + ".PlaybackFinished"
+)
 
 type service struct {
-	player player
+	player   player
+	requests *playRequests
 }
 
 func startService(player player) error {
@@ -56,6 +64,17 @@ func startService(player player) error {
 	s := &service{
 		player: player,
 	}
+	s.player.emit = func(name string, args ...interface{}) {
+		if err := conn.Emit(dbusPath, name, args...); err != nil {
+			log.Printf("could not send %s signal: %v", name, err)
+		}
+	}
+	s.requests = newPlayRequests(&s.player, func(result playResult) {
+		err := conn.Emit(dbusPath, playFinishedSignal, result.ID, result.Played, result.Reason, result.Error)
+		if err != nil {
+			log.Printf("could not signal that request %d finished: %v", result.ID, err)
+		}
+	})
 	if err := conn.Export(s, dbusPath, dbusName); err != nil {
 		return err
 	}
@@ -65,54 +84,114 @@ func startService(player player) error {
 	return nil
 }
 
+// PlayFromId plays an audio file. As there is no reason given, a muted sound is
+// reported with the Muted error so that it can be told apart from a sound that
+// wasn't played because a higher priority sound was playing.
 func (s service) PlayFromId(fileId, volume, priority int, eventRaw string) (bool, *dbus.Error) {
-	mu.Lock()
-	defer mu.Unlock()
+	played, reason, err := s.PlayFromIdWithReason(fileId, volume, priority, eventRaw)
+	if err == nil && reason == reasonMuted {
+		return false, dbusErr(audiobaitclient.ErrMuted)
+	}
+	return played, err
+}
+
+// PlayFromIdWithReason is PlayFromId but also returns the reason the sound wasn't played.
+func (s service) PlayFromIdWithReason(fileId, volume, priority int, eventRaw string) (bool, string, *dbus.Error) {
 	var event *eventclient.Event
 	if len(eventRaw) != 0 {
 		if err := json.Unmarshal([]byte(eventRaw), &event); err != nil {
-			return false, dbusErr(err)
+			return false, "", dbusErr(withKind(audiobaitclient.ErrInvalidEvent, err))
 		}
 	}
-	played, err := s.player.PlayFromId(fileId, volume, priority, event)
+	played, reason, err := s.player.PlayFromId(fileId, volume, priority, event)
 	if err != nil {
-		return played, dbusErr(err)
+		return played, reason, dbusErr(err)
 	}
-	return played, nil
+	return played, reason, nil
 }
 
-func (s service) PlayTestSound(volume int) *dbus.Error {
-	mu.Lock()
-	defer mu.Unlock()
-	err := s.player.PlayTestSound(volume)
+// PlayFromIdAsync queues a sound to be played and returns the request's ID straight
+// away. The PlayFinished signal is sent with the result once it has been played.
+func (s service) PlayFromIdAsync(fileId, volume, priority int, eventRaw string) (int, *dbus.Error) {
+	var event *eventclient.Event
+	if len(eventRaw) != 0 {
+		if err := json.Unmarshal([]byte(eventRaw), &event); err != nil {
+			return 0, dbusErr(withKind(audiobaitclient.ErrInvalidEvent, err))
+		}
+	}
+	return s.requests.add(fileId, volume, priority, event), nil
+}
+
+// RequestResult returns whether a request from PlayFromIdAsync has finished, and
+// if it has whether the sound was played, the reason it wasn't and any error.
+func (s service) RequestResult(requestId int) (bool, bool, string, string, *dbus.Error) {
+	result, done, err := s.requests.result(requestId)
 	if err != nil {
-		return dbusErr(err)
+		return false, false, "", "", dbusErr(err)
 	}
-	return nil
+	return done, result.Played, result.Reason, result.Error, nil
+}
+
+func (s service) PlayTrigger(trigger string, volume, priority int, makeEvent bool) (bool, *dbus.Error) {
+	played, _, err := s.PlayTriggerWithReason(trigger, volume, priority, makeEvent)
+	return played, err
 }
 
-func dbusErr(err error) *dbus.Error {
-	if err == nil {
-		return nil
+// PlayTriggerWithReason is PlayTrigger but also returns the reason no sounds were played.
+func (s service) PlayTriggerWithReason(trigger string, volume, priority int, makeEvent bool) (bool, string, *dbus.Error) {
+	played, reason, err := s.player.PlayTrigger(trigger, volume, priority, makeEvent)
+	if err != nil {
+		return played, reason, dbusErr(err)
 	}
-	return &dbus.Error{
-		Name: dbusName + "." + getCallerName(),
-		Body: []interface{}{err.Error()},
+	return played, reason, nil
+}
+
+// Stop cuts short the sound that is playing. It returns false if no sound was playing.
+func (s service) Stop() (bool, *dbus.Error) {
+	return s.player.Stop(), nil
+}
+
+// Status returns a JSON description of what audiobait is doing, see audiobaitclient.Status.
+func (s service) Status() (string, *dbus.Error) {
+	raw, err := json.Marshal(s.player.status.status())
+	if err != nil {
+		return "", dbusErr(err)
 	}
+	return string(raw), nil
 }
 
-func getCallerName() string {
-	fpcs := make([]uintptr, 1)
-	n := runtime.Callers(3, fpcs)
-	if n == 0 {
-		return ""
+// Mute stops sounds with a priority up to and including the given priority from
+// being played for the duration in seconds, or until Unmute is called if it is 0.
+func (s service) Mute(priority, duration int) *dbus.Error {
+	if duration < 0 {
+		return dbusErr(withKind(audiobaitclient.ErrInvalidArgument, errors.New("duration must not be negative")))
 	}
-	caller := runtime.FuncForPC(fpcs[0] - 1)
-	if caller == nil {
-		return ""
+	return dbusErr(s.player.muter.mute(priority, time.Duration(duration)*time.Second))
+}
+
+// Unmute removes the mute set by Mute.
+func (s service) Unmute() *dbus.Error {
+	return dbusErr(s.player.muter.unmute())
+}
+
+func (s service) PlayTestSound(volume int) *dbus.Error {
+	err := s.player.PlayTestSound(volume)
+	if err != nil {
+		return dbusErr(err)
 	}
-	funcNames := strings.Split(caller.Name(), ".")
-	return funcNames[len(funcNames)-1]
+	return nil
+}
+
+// playbackArgs are the arguments of the PlaybackStarted signal, which start the
+// arguments of the PlaybackFinished signal. Times are in nanoseconds since the Unix
+// epoch, and durations are in nanoseconds. The duration is 0 if it isn't known.
+var playbackArgs = []introspect.Arg{
+	{Name: "fileId", Type: "i"},
+	{Name: "volume", Type: "i"},
+	{Name: "priority", Type: "i"},
+	{Name: "source", Type: "s"},
+	{Name: "start", Type: "x"},
+	{Name: "duration", Type: "x"},
 }
 
 func genIntrospectable(v interface{}) introspect.Introspectable {
@@ -120,25 +199,22 @@ func genIntrospectable(v interface{}) introspect.Introspectable {
 		Interfaces: []introspect.Interface{{
 			Name:    dbusName,
 			Methods: introspect.Methods(v),
+			Signals: []introspect.Signal{{
+				Name: "PlayFinished",
+				Args: []introspect.Arg{
+					{Name: "requestId", Type: "i"},
+					{Name: "played", Type: "b"},
+					{Name: "reason", Type: "s"},
+					{Name: "error", Type: "s"},
+				},
+			}, {
+				Name: "PlaybackStarted",
+				Args: playbackArgs,
+			}, {
+				Name: "PlaybackFinished",
+				Args: append(playbackArgs, introspect.Arg{Name: "end", Type: "x"}, introspect.Arg{Name: "elapsed", Type: "x"}),
+			}},
 		}},
 	}
 	return introspect.NewIntrospectable(node)
 }
-
-/*	//TODO
-func (s *service) PlayTrigger(trigger string, volume, priority int, makeEvent bool) (bool, *dbus.Error) {
-	mu.Lock()
-	defer mu.Unlock()
-	return true, nil
-}
-
-func (s service) Status() *dbus.Error {
-	mu.Lock()
-	defer mu.Unlock()
-	return nil
-}
-
-func (s service) Mute(priority int) *dbus.Error {
-	return nil
-}
-*/
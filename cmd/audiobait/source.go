@@ -0,0 +1,380 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/TheCacophonyProject/audiobait/v3/audiofilelibrary"
+	"github.com/TheCacophonyProject/audiobait/v3/playlist"
+)
+
+// Names of the schedule sources used in the config.
+const (
+	apiSourceName       = "api"
+	localSourceName     = "local"
+	removableSourceName = "removable"
+)
+
+const (
+	// How often local directories and removable media are checked for a new schedule bundle.
+	bundleCheckInterval = 30 * time.Second
+
+	// bundleDirName is the directory on removable media that a schedule bundle can be put in.
+	// A bundle at the top of the drive is also found.
+	bundleDirName = "audiobait"
+)
+
+// Ranks of the schedule sources. While the schedule in use came from a source
+// that is still present, sources with a lower rank are ignored, so a schedule
+// on removable media wins over a local one, which wins over the API's.
+const (
+	apiSourceRank = iota + 1
+	localSourceRank
+	removableSourceRank
+)
+
+// ScheduleSource is somewhere that new schedules can come from.
+type ScheduleSource interface {
+	// Name is used to identify the source in the logs.
+	Name() string
+	// Fetch gets the source's schedule once its audio files are in the audio
+	// directory. It returns nil if the source has nothing new. installMu must be
+	// held while files are put in the audio directory.
+	Fetch() (*playlist.Schedule, error)
+	// NextCheck is how long to wait before fetching from the source again.
+	NextCheck() time.Duration
+	// Rank orders the sources, see apiSourceRank.
+	Rank() int
+	// Present returns true if the source still has a schedule, for example
+	// because the drive it was on hasn't been removed.
+	Present() bool
+	// InUse is called with true once the schedule last fetched has been saved,
+	// and with false when a schedule from another source replaces it.
+	InUse(inUse bool)
+}
+
+// NewScheduleSources creates the schedule sources named in the config.
+func NewScheduleSources(conf *Config) ([]ScheduleSource, error) {
+	var sources []ScheduleSource
+	for _, name := range conf.ScheduleSources {
+		switch name {
+		case apiSourceName:
+			sources = append(sources, newAPISource(conf.Dir))
+		case localSourceName:
+			sources = append(sources, newDirSource(conf.ScheduleDir, conf.Dir))
+		case removableSourceName:
+			sources = append(sources, newRemovableSource(conf.MediaDirs, conf.Dir))
+		default:
+			return nil, fmt.Errorf("unknown schedule source '%s'", name)
+		}
+	}
+	return sources, nil
+}
+
+// bundle is a directory holding a schedule.json and the audio files it uses, named as
+// they are in the audio directory (e.g. "bellbird-6.mp3").
+type bundle struct {
+	mu sync.Mutex
+	// imported is the hash of the schedule file in use, so a bundle is only
+	// activated when it first appears or changes.
+	imported string
+	// fetched is the hash of the schedule file last fetched, which becomes
+	// imported once the schedule has been saved.
+	fetched string
+	// reported is the hash of the last schedule file that couldn't be imported.
+	// The bundle is tried again at each check, but the problem is only reported once.
+	reported string
+}
+
+// fetch validates the schedule in the bundle directory and copies its audio files
+// into audioDir. It returns nil if the schedule is the one in use.
+func (b *bundle) fetch(dir, audioDir string) (*playlist.Schedule, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, playlist.ScheduleFilename))
+	if err != nil {
+		return nil, err
+	}
+	hash := fmt.Sprintf("%s:%x", dir, sha256.Sum256(data))
+	b.mu.Lock()
+	imported, reported := b.imported, b.reported
+	b.mu.Unlock()
+	if hash == imported {
+		return nil, nil
+	}
+	schedule, err := b.load(dir, audioDir)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		if hash == reported {
+			return nil, nil
+		}
+		b.reported = hash
+		return nil, err
+	}
+	b.fetched, b.reported = hash, ""
+	return schedule, nil
+}
+
+// inUse records whether the schedule last fetched is in use. Once it isn't the
+// bundle is imported again the next time it is fetched.
+func (b *bundle) inUse(inUse bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if inUse {
+		b.imported = b.fetched
+	} else {
+		b.imported = ""
+	}
+}
+
+// reset forgets the bundle, for when it has gone.
+func (b *bundle) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.imported, b.fetched, b.reported = "", "", ""
+}
+
+// load validates the schedule in the bundle directory and copies its audio files into audioDir.
+func (b *bundle) load(dir, audioDir string) (*playlist.Schedule, error) {
+	schedule, err := playlist.ReadSchedule(dir)
+	if err != nil {
+		return nil, fmt.Errorf("bad schedule in %s: %v", dir, err)
+	}
+	library, err := audiofilelibrary.OpenLibrary(dir)
+	if err != nil {
+		return nil, err
+	}
+	fileIDs := schedule.GetReferencedSounds()
+	for _, fileID := range fileIDs {
+		if _, exists := library.GetFileNameOnDisk(fileID); !exists {
+			return nil, fmt.Errorf("audio file for %d is missing from %s", fileID, dir)
+		}
+		// Check the file against the bundle's index, if it has one, so a corrupt
+		// file isn't trusted.
+		if err := library.Verify(fileID); err != nil {
+			return nil, fmt.Errorf("audio file for %d in %s is not valid: %v", fileID, dir, err)
+		}
+	}
+	installMu.Lock()
+	defer installMu.Unlock()
+	audioLibrary, err := openLibrary(audioDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileID := range fileIDs {
+		filename, _ := library.GetFileNameOnDisk(fileID)
+		// Keep a corrupt copy in quarantine rather than just replacing it.
+		if err := audioLibrary.Verify(fileID); err != nil {
+			log.Printf("%s is not valid: %v", filename, err)
+			quarantine(audioLibrary, fileID)
+		}
+		copied, err := copyFile(filepath.Join(dir, filename), filepath.Join(audioDir, filename))
+		if err != nil {
+			return nil, fmt.Errorf("could not copy %s: %v", filename, err)
+		}
+		if copied {
+			filesChanged++
+		}
+		// Keep what the bundle's own index says about the file.
+		info, indexed := library.FileInfo(fileID)
+		if !indexed {
+			info = audiofilelibrary.FileInfo{ID: fileID}
+		}
+		info.Downloaded = time.Time{}
+		indexFile(audioDir, info, copied)
+	}
+	log.Printf("found schedule bundle in %s", dir)
+	return schedule, nil
+}
+
+// copyFile copies src to dst, unless dst is already there with the same contents.
+// The copy is written to a temporary file first so a partial copy is never used.
+// It returns whether the file was copied.
+func copyFile(src, dst string) (bool, error) {
+	same, err := sameContents(src, dst)
+	if err != nil {
+		return false, err
+	}
+	if same {
+		return false, nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return false, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, os.Rename(tmp, dst)
+}
+
+// sameContents returns true if dst exists and has the same contents as src.
+func sameContents(src, dst string) (bool, error) {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil || dstInfo.Size() != srcInfo.Size() {
+		return false, nil
+	}
+	srcHash, err := audiofilelibrary.HashFile(src)
+	if err != nil {
+		return false, err
+	}
+	dstHash, err := audiofilelibrary.HashFile(dst)
+	return err == nil && srcHash == dstHash, nil
+}
+
+// dirSource reads a schedule bundle from a local directory.
+type dirSource struct {
+	dir      string
+	audioDir string
+	bundle   bundle
+}
+
+func newDirSource(dir, audioDir string) *dirSource {
+	return &dirSource{dir: dir, audioDir: audioDir}
+}
+
+func (s *dirSource) Name() string {
+	return s.dir
+}
+
+func (s *dirSource) Fetch() (*playlist.Schedule, error) {
+	if !s.Present() {
+		s.bundle.reset()
+		return nil, nil
+	}
+	return s.bundle.fetch(s.dir, s.audioDir)
+}
+
+func (s *dirSource) NextCheck() time.Duration {
+	return bundleCheckInterval
+}
+
+func (s *dirSource) Rank() int {
+	return localSourceRank
+}
+
+func (s *dirSource) Present() bool {
+	_, err := os.Stat(filepath.Join(s.dir, playlist.ScheduleFilename))
+	return err == nil
+}
+
+func (s *dirSource) InUse(inUse bool) {
+	s.bundle.inUse(inUse)
+}
+
+// removableSource looks for a schedule bundle on drives mounted under the media
+// directories, such as /media/<label> or /media/<user>/<label>.
+type removableSource struct {
+	mediaDirs []string
+	audioDir  string
+	bundle    bundle
+}
+
+func newRemovableSource(mediaDirs []string, audioDir string) *removableSource {
+	return &removableSource{mediaDirs: mediaDirs, audioDir: audioDir}
+}
+
+func (s *removableSource) Name() string {
+	return removableSourceName
+}
+
+func (s *removableSource) Fetch() (*playlist.Schedule, error) {
+	dir := s.findBundle()
+	if dir == "" {
+		// Allow the same bundle to be used again once it has been removed.
+		s.bundle.reset()
+		return nil, nil
+	}
+	return s.bundle.fetch(dir, s.audioDir)
+}
+
+func (s *removableSource) NextCheck() time.Duration {
+	return bundleCheckInterval
+}
+
+func (s *removableSource) Rank() int {
+	return removableSourceRank
+}
+
+func (s *removableSource) Present() bool {
+	return s.findBundle() != ""
+}
+
+func (s *removableSource) InUse(inUse bool) {
+	s.bundle.inUse(inUse)
+}
+
+// findBundle returns the first directory on a mounted drive with a schedule
+// in it, or "" if there isn't one.
+func (s *removableSource) findBundle() string {
+	for _, mediaDir := range s.mediaDirs {
+		drives := subDirs(mediaDir)
+		for _, dir := range drives {
+			drives = append(drives, subDirs(dir)...)
+		}
+		for _, drive := range drives {
+			for _, dir := range []string{filepath.Join(drive, bundleDirName), drive} {
+				if _, err := os.Stat(filepath.Join(dir, playlist.ScheduleFilename)); err == nil {
+					return dir
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// subDirs lists the directories in dir.
+func subDirs(dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, info := range infos {
+		if info.IsDir() {
+			dirs = append(dirs, filepath.Join(dir, info.Name()))
+		}
+	}
+	return dirs
+}
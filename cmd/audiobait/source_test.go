@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/audiobait/v3/audiofilelibrary"
+	"github.com/TheCacophonyProject/audiobait/v3/playlist"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "audiobait-test")
+	require.NoError(t, err)
+	return dir
+}
+
+// writeBundle writes a schedule using file 3, and the file if withAudio is set.
+func writeBundle(t *testing.T, dir, description string, withAudio bool) {
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	schedule := playlist.Schedule{
+		Description: description,
+		AllSounds:   []int{3},
+		Combos: []playlist.Combo{{
+			From:    *playlist.NewTimeOfDay("19:00"),
+			Until:   *playlist.NewTimeOfDay("20:00"),
+			Every:   600,
+			Waits:   []int{0},
+			Volumes: []int{5},
+			Sounds:  []string{"3"},
+		}},
+	}
+	data, err := json.Marshal(&schedule)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, playlist.ScheduleFilename), data, 0644))
+	if withAudio {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "morepork-3.mp3"), []byte("sound"), 0644))
+	}
+}
+
+func TestDirSourceCopiesBundle(t *testing.T) {
+	bundleDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(bundleDir)
+	defer os.RemoveAll(audioDir)
+	writeBundle(t, bundleDir, "local", true)
+
+	source := newDirSource(bundleDir, audioDir)
+	schedule, err := source.Fetch()
+	require.NoError(t, err)
+	assert.Equal(t, "local", schedule.Description)
+	data, err := ioutil.ReadFile(filepath.Join(audioDir, "morepork-3.mp3"))
+	require.NoError(t, err)
+	assert.Equal(t, "sound", string(data))
+
+	// Nothing new until the bundle changes, once the schedule is in use.
+	source.InUse(true)
+	schedule, err = source.Fetch()
+	assert.NoError(t, err)
+	assert.Nil(t, schedule)
+
+	writeBundle(t, bundleDir, "changed", true)
+	schedule, err = source.Fetch()
+	require.NoError(t, err)
+	assert.Equal(t, "changed", schedule.Description)
+}
+
+func TestDirSourceWithoutBundle(t *testing.T) {
+	bundleDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(bundleDir)
+	defer os.RemoveAll(audioDir)
+
+	schedule, err := newDirSource(bundleDir, audioDir).Fetch()
+	assert.NoError(t, err)
+	assert.Nil(t, schedule)
+}
+
+func TestBundleWithMissingAudioIsRejected(t *testing.T) {
+	bundleDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(bundleDir)
+	defer os.RemoveAll(audioDir)
+	writeBundle(t, bundleDir, "local", false)
+
+	source := newDirSource(bundleDir, audioDir)
+	_, err := source.Fetch()
+	assert.Error(t, err)
+
+	// The bad bundle is only reported once.
+	schedule, err := source.Fetch()
+	assert.NoError(t, err)
+	assert.Nil(t, schedule)
+
+	// It is tried again, so it is imported once the audio is there.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bundleDir, "morepork-3.mp3"), []byte("sound"), 0644))
+	schedule, err = source.Fetch()
+	require.NoError(t, err)
+	assert.Equal(t, "local", schedule.Description)
+}
+
+func TestRemovableSourceFindsBundleOnDrive(t *testing.T) {
+	mediaDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(mediaDir)
+	defer os.RemoveAll(audioDir)
+	require.NoError(t, os.MkdirAll(filepath.Join(mediaDir, "pi", "EMPTY"), 0755))
+	source := newRemovableSource([]string{filepath.Join(mediaDir, "missing"), mediaDir}, audioDir)
+
+	schedule, err := source.Fetch()
+	assert.NoError(t, err)
+	assert.Nil(t, schedule)
+
+	writeBundle(t, filepath.Join(mediaDir, "pi", "USB", bundleDirName), "usb", true)
+	schedule, err = source.Fetch()
+	require.NoError(t, err)
+	assert.Equal(t, "usb", schedule.Description)
+	assert.FileExists(t, filepath.Join(audioDir, "morepork-3.mp3"))
+}
+
+func TestDownloaderActivatesSchedulesFromSource(t *testing.T) {
+	bundleDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(bundleDir)
+	defer os.RemoveAll(audioDir)
+	writeBundle(t, bundleDir, "local", true)
+
+	dl := &Downloader{audioDir: audioDir}
+	changed, err := dl.update(newDirSource(bundleDir, audioDir))
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	schedule, err := playlist.LoadScheduleFromDisk(audioDir)
+	require.NoError(t, err)
+	assert.Equal(t, "local", schedule.Description)
+}
+
+func TestUnknownScheduleSource(t *testing.T) {
+	conf := &Config{audiobaitConfig: audiobaitConfig{ScheduleSources: []string{"api", "carrier-pigeon"}}}
+	_, err := NewScheduleSources(conf)
+	assert.Error(t, err)
+}
+
+func TestImportedFilesAreIndexed(t *testing.T) {
+	openLibrary = audiofilelibrary.OpenLibrary
+	bundleDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(bundleDir)
+	defer os.RemoveAll(audioDir)
+	writeBundle(t, bundleDir, "local", true)
+
+	_, err := newDirSource(bundleDir, audioDir).Fetch()
+	require.NoError(t, err)
+	library, err := audiofilelibrary.OpenLibrary(audioDir)
+	require.NoError(t, err)
+	info, indexed := library.FileInfo(3)
+	assert.True(t, indexed)
+	assert.Equal(t, "morepork-3.mp3", info.FileName)
+	assert.Equal(t, int64(5), info.Size)
+	assert.False(t, info.Downloaded.IsZero())
+}
+
+func TestCorruptImportedFileIsReplaced(t *testing.T) {
+	openLibrary = audiofilelibrary.OpenLibrary
+	bundleDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(bundleDir)
+	defer os.RemoveAll(audioDir)
+	writeBundle(t, bundleDir, "local", true)
+	source := newDirSource(bundleDir, audioDir)
+	_, err := source.Fetch()
+	require.NoError(t, err)
+
+	// A copy of the same size isn't replaced unless it is found to be corrupt.
+	path := filepath.Join(audioDir, "morepork-3.mp3")
+	require.NoError(t, ioutil.WriteFile(path, []byte("sourd"), 0644))
+	writeBundle(t, bundleDir, "changed", true)
+	_, err = source.Fetch()
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "sound", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(audioDir, audiofilelibrary.QuarantineDirname, "morepork-3.mp3"))
+	require.NoError(t, err)
+	assert.Equal(t, "sourd", string(data))
+}
+
+func TestVerifyLibraryQuarantinesCorruptFiles(t *testing.T) {
+	openLibrary = audiofilelibrary.OpenLibrary
+	bundleDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(bundleDir)
+	defer os.RemoveAll(audioDir)
+	writeBundle(t, bundleDir, "local", true)
+	_, err := newDirSource(bundleDir, audioDir).Fetch()
+	require.NoError(t, err)
+
+	verifyLibrary(audioDir)
+	_, err = os.Stat(filepath.Join(audioDir, "morepork-3.mp3"))
+	assert.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(audioDir, "morepork-3.mp3"), []byte("sourd"), 0644))
+	verifyLibrary(audioDir)
+	_, err = os.Stat(filepath.Join(audioDir, "morepork-3.mp3"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestBundleWithCorruptAudioIsRejected(t *testing.T) {
+	openLibrary = audiofilelibrary.OpenLibrary
+	bundleDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(bundleDir)
+	defer os.RemoveAll(audioDir)
+	writeBundle(t, bundleDir, "local", true)
+	bundleLibrary, err := audiofilelibrary.OpenLibrary(bundleDir)
+	require.NoError(t, err)
+	_, err = bundleLibrary.AddToIndex(audiofilelibrary.FileInfo{ID: 3})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bundleDir, "morepork-3.mp3"), []byte("sourd"), 0644))
+
+	_, err = newDirSource(bundleDir, audioDir).Fetch()
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(audioDir, "morepork-3.mp3"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// blockingSource is a source whose Fetch blocks until it is released.
+type blockingSource struct {
+	fetching chan struct{}
+	release  chan struct{}
+}
+
+func (s blockingSource) Name() string { return "blocking" }
+
+func (s blockingSource) Fetch() (*playlist.Schedule, error) {
+	close(s.fetching)
+	<-s.release
+	return nil, nil
+}
+
+func (s blockingSource) NextCheck() time.Duration { return time.Hour }
+
+func (s blockingSource) Rank() int { return apiSourceRank }
+
+func (s blockingSource) Present() bool { return true }
+
+func (s blockingSource) InUse(inUse bool) {}
+
+// fixedSource is a source that always has the same schedule, like the API.
+type fixedSource struct {
+	schedule *playlist.Schedule
+}
+
+func (s fixedSource) Name() string { return "fixed" }
+
+func (s fixedSource) Fetch() (*playlist.Schedule, error) { return s.schedule, nil }
+
+func (s fixedSource) NextCheck() time.Duration { return time.Hour }
+
+func (s fixedSource) Rank() int { return apiSourceRank }
+
+func (s fixedSource) Present() bool { return true }
+
+func (s fixedSource) InUse(inUse bool) {}
+
+func loadDescription(t *testing.T, audioDir string) string {
+	schedule, err := playlist.LoadScheduleFromDisk(audioDir)
+	require.NoError(t, err)
+	return schedule.Description
+}
+
+func TestRemovableScheduleWinsWhileDriveIsPresent(t *testing.T) {
+	mediaDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(mediaDir)
+	defer os.RemoveAll(audioDir)
+	usbDir := filepath.Join(mediaDir, "pi", "USB")
+	writeBundle(t, filepath.Join(usbDir, bundleDirName), "usb", true)
+	removable := newRemovableSource([]string{mediaDir}, audioDir)
+	api := fixedSource{schedule: &playlist.Schedule{Description: "api"}}
+	dl := &Downloader{audioDir: audioDir}
+
+	changed, err := dl.update(removable)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	// The API doesn't replace the schedule from the drive.
+	changed, err = dl.update(api)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, "usb", loadDescription(t, audioDir))
+
+	// Once the drive is removed the API's schedule is used...
+	require.NoError(t, os.RemoveAll(usbDir))
+	_, err = dl.update(removable)
+	require.NoError(t, err)
+	changed, err = dl.update(api)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "api", loadDescription(t, audioDir))
+
+	// ...until it is put back.
+	writeBundle(t, filepath.Join(usbDir, bundleDirName), "usb", true)
+	changed, err = dl.update(removable)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "usb", loadDescription(t, audioDir))
+}
+
+func TestBundleIsNotImportedUntilSaved(t *testing.T) {
+	bundleDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(bundleDir)
+	defer os.RemoveAll(audioDir)
+	writeBundle(t, bundleDir, "local", true)
+	local := newDirSource(bundleDir, audioDir)
+	dl := &Downloader{audioDir: audioDir}
+
+	// A directory in the way of the schedule file makes saving fail.
+	scheduleFile := filepath.Join(audioDir, playlist.ScheduleFilename)
+	require.NoError(t, os.MkdirAll(filepath.Join(scheduleFile, "x"), 0755))
+	_, err := dl.update(local)
+	require.Error(t, err)
+
+	require.NoError(t, os.RemoveAll(scheduleFile))
+	changed, err := dl.update(local)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "local", loadDescription(t, audioDir))
+}
+
+func TestSlowSourceDoesNotBlockOthers(t *testing.T) {
+	bundleDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(bundleDir)
+	defer os.RemoveAll(audioDir)
+	writeBundle(t, bundleDir, "local", true)
+	dl := &Downloader{audioDir: audioDir}
+	slow := blockingSource{fetching: make(chan struct{}), release: make(chan struct{})}
+	defer close(slow.release)
+	go dl.update(slow)
+	<-slow.fetching
+
+	changed, err := dl.update(newDirSource(bundleDir, audioDir))
+	require.NoError(t, err)
+	assert.True(t, changed)
+}
+
+func TestCopyFileComparesContents(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	require.NoError(t, ioutil.WriteFile(src, []byte("sound"), 0644))
+
+	copied, err := copyFile(src, dst)
+	require.NoError(t, err)
+	assert.True(t, copied)
+	copied, err = copyFile(src, dst)
+	require.NoError(t, err)
+	assert.False(t, copied)
+
+	require.NoError(t, ioutil.WriteFile(dst, []byte("other"), 0644))
+	copied, err = copyFile(src, dst)
+	require.NoError(t, err)
+	assert.True(t, copied)
+	data, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "sound", string(data))
+}
+
+func TestPlayerReloadsWhenQuarantinedFileIsReplaced(t *testing.T) {
+	openLibrary = audiofilelibrary.OpenLibrary
+	bundleDir, audioDir := tempDir(t), tempDir(t)
+	defer os.RemoveAll(bundleDir)
+	defer os.RemoveAll(audioDir)
+	writeBundle(t, bundleDir, "local", true)
+	changed, err := (&Downloader{audioDir: audioDir}).update(newDirSource(bundleDir, audioDir))
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	// The file is found to be corrupt at startup so there is nothing to play.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(audioDir, "morepork-3.mp3"), []byte("sourd"), 0644))
+	verifyLibrary(audioDir)
+	_, _, err = createPlayer(audioDir, new(playlist.ActualClock), playlist.Location{}, nil)
+	require.Error(t, err)
+
+	// Fetching the same schedule again replaces the file, so the player is reloaded.
+	dl := NewDownloader(audioDir, nil, newDirSource(bundleDir, audioDir))
+	defer dl.Stop()
+	select {
+	case <-dl.Updated():
+	case <-time.After(5 * time.Second):
+		t.Fatal("player wasn't told to reload")
+	}
+	_, _, err = createPlayer(audioDir, new(playlist.ActualClock), playlist.Location{}, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,159 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sync"
+
+	"github.com/TheCacophonyProject/audiobait/v3/audiobaitclient"
+	"github.com/TheCacophonyProject/audiobait/v3/playlist"
+)
+
+// statusTracker keeps track of what audiobait is doing for the Status method.
+// A nil statusTracker ignores updates and reports an empty status.
+type statusTracker struct {
+	soundDir string
+	muter    *muter
+
+	mu             sync.Mutex
+	schedulePlayer *playlist.SchedulePlayer
+	schedule       *playlist.Schedule
+	scheduleHash   string
+	playing        *audiobaitclient.PlayingStatus
+	lastDownload   *audiobaitclient.DownloadStatus
+}
+
+func newStatusTracker(soundDir string, muter *muter) *statusTracker {
+	return &statusTracker{
+		soundDir: soundDir,
+		muter:    muter,
+	}
+}
+
+// setSchedule records the schedule being played and the player playing it.
+func (s *statusTracker) setSchedule(schedulePlayer *playlist.SchedulePlayer, schedule *playlist.Schedule) {
+	if s == nil {
+		return
+	}
+	hash := ""
+	if data, err := ioutil.ReadFile(filepath.Join(s.soundDir, playlist.ScheduleFilename)); err == nil {
+		hash = fmt.Sprintf("%x", sha256.Sum256(data))
+	} else {
+		log.Printf("could not hash schedule: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedulePlayer = schedulePlayer
+	s.schedule = schedule
+	s.scheduleHash = hash
+}
+
+// startedPlaying records the sound that has started playing.
+func (s *statusTracker) startedPlaying(playing audiobaitclient.PlayingStatus) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.playing = &playing
+}
+
+// finishedPlaying records that no sound is playing.
+func (s *statusTracker) finishedPlaying() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.playing = nil
+}
+
+// downloaded records the result of checking a source for a new schedule.
+func (s *statusTracker) downloaded(source string, updated bool, err error) {
+	if s == nil {
+		return
+	}
+	download := &audiobaitclient.DownloadStatus{
+		Source:  source,
+		Time:    now(),
+		Updated: updated,
+	}
+	if err != nil {
+		download.Error = err.Error()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastDownload = download
+}
+
+// status describes what audiobait is doing now.
+func (s *statusTracker) status() audiobaitclient.Status {
+	if s == nil {
+		return audiobaitclient.Status{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var status audiobaitclient.Status
+	if s.schedule != nil {
+		status.Schedule = s.schedule.Description
+		status.ScheduleHash = s.scheduleHash
+		if len(s.schedule.Combos) > 0 {
+			if s.schedulePlayer.IsSoundPlayingDay(*s.schedule) {
+				status.Tonight = audiobaitclient.PlayNight
+				index, start, active := s.schedulePlayer.NextCombo(*s.schedule)
+				status.Combo = &audiobaitclient.ComboStatus{
+					Index:   index,
+					Start:   start,
+					Active:  active,
+					Trigger: s.schedule.Combos[index].Trigger,
+				}
+			} else {
+				status.Tonight = audiobaitclient.ControlNight
+			}
+		}
+	}
+	if s.playing != nil {
+		playing := *s.playing
+		playing.Elapsed = now().Sub(playing.Started)
+		status.Playing = &playing
+	}
+	if mute := s.muter.current(); mute != nil {
+		status.Mute = &audiobaitclient.MuteStatus{
+			Priority: mute.Priority,
+			Until:    mute.Until,
+		}
+	}
+	if s.lastDownload != nil {
+		download := *s.lastDownload
+		status.LastDownload = &download
+	}
+	if library, err := openLibrary(s.soundDir); err == nil {
+		status.LibrarySize = len(library.FilesByID)
+	} else {
+		log.Printf("could not open library for status: %v", err)
+	}
+	return status
+}
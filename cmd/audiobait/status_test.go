@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/audiobait/v3/audiobaitclient"
+	"github.com/TheCacophonyProject/audiobait/v3/playlist"
+	"github.com/TheCacophonyProject/event-reporter/eventclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusWithoutSchedule(t *testing.T) {
+	mockOpenLibrary(map[int]string{1: "a", 2: "b"}, nil)
+	s := newStatusTracker("", nil)
+	assert.Equal(t, audiobaitclient.Status{LibrarySize: 2}, s.status())
+}
+
+func TestStatusWithoutTracker(t *testing.T) {
+	s := service{}
+	raw, err := s.Status()
+	assert.Nil(t, err)
+	var status audiobaitclient.Status
+	require.NoError(t, json.Unmarshal([]byte(raw), &status))
+	assert.Equal(t, audiobaitclient.Status{}, status)
+}
+
+func TestStatusOfSchedule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiobait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	mockOpenLibrary(map[int]string{1: "a"}, nil)
+
+	schedule := playlist.Schedule{
+		Description: "lure",
+		AllSounds:   []int{1},
+		Combos: []playlist.Combo{{
+			From:    *playlist.NewTimeOfDay("19:00"),
+			Until:   *playlist.NewTimeOfDay("20:00"),
+			Every:   600,
+			Waits:   []int{0},
+			Volumes: []int{5},
+			Sounds:  []string{"1"},
+		}},
+	}
+	data, err := json.Marshal(&schedule)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, playlist.ScheduleFilename), data, 0644))
+
+	clock := playlist.NewFakeClock(time.Date(2019, time.January, 1, 13, 0, 0, 0, time.UTC))
+	schedulePlayer := playlist.NewPlayer(map[int]string{1: "a"}, dir)
+	schedulePlayer.SetClock(clock)
+
+	s := newStatusTracker(dir, nil)
+	s.setSchedule(schedulePlayer, &schedule)
+	status := s.status()
+	assert.Equal(t, "lure", status.Schedule)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(data)), status.ScheduleHash)
+	assert.Equal(t, audiobaitclient.PlayNight, status.Tonight)
+	assert.Equal(t, &audiobaitclient.ComboStatus{
+		Index: 0,
+		Start: time.Date(2019, time.January, 1, 19, 0, 0, 0, time.UTC),
+	}, status.Combo)
+
+	schedule.ControlNights = 1
+	assert.Equal(t, audiobaitclient.ControlNight, s.status().Tonight)
+	assert.Nil(t, s.status().Combo)
+}
+
+func TestStatusOfPlayingSound(t *testing.T) {
+	newFakeNow()
+	started := now()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// A second of sound.
+	writeTestWAV(t, dir, make([]int16, 8000))
+	mockOpenLibrary(map[int]string{1: "in.wav"}, nil)
+	mockSaveEvent(nil)
+	s := newStatusTracker("", nil)
+	playing := make(chan audiobaitclient.Status, 1)
+	testPlayer := player{
+		soundDir: dir,
+		soundCard: soundCardFunc(func() {
+			now = func() time.Time { return started.Add(300 * time.Millisecond) }
+			playing <- s.status()
+		}),
+		arbiter: newArbiter(true),
+		status:  s,
+	}
+
+	played, _, err := testPlayer.PlayFromId(1, 2, 3, &eventclient.Event{})
+	assert.NoError(t, err)
+	assert.True(t, played)
+	assert.Equal(t, &audiobaitclient.PlayingStatus{
+		FileID:   1,
+		FileName: "in.wav",
+		Volume:   2,
+		Priority: 3,
+		Started:  started,
+		Duration: time.Second,
+		Elapsed:  300 * time.Millisecond,
+	}, (<-playing).Playing)
+	assert.Nil(t, s.status().Playing)
+}
+
+func TestStatusOfMuteAndDownload(t *testing.T) {
+	newFakeNow()
+	path, cleanup := tempMuteFile(t)
+	defer cleanup()
+	mockOpenLibrary(map[int]string{}, nil)
+	m := newMuter(path)
+	require.NoError(t, m.mute(4, time.Hour))
+	s := newStatusTracker("", m)
+
+	s.downloaded(apiSourceName, false, errors.New("no connection"))
+	assert.Equal(t, audiobaitclient.Status{
+		Mute: &audiobaitclient.MuteStatus{Priority: 4, Until: now().Add(time.Hour)},
+		LastDownload: &audiobaitclient.DownloadStatus{
+			Source: apiSourceName,
+			Time:   now(),
+			Error:  "no connection",
+		},
+	}, s.status())
+}
+
+// soundCardFunc is a sound card that calls the function instead of playing a sound.
+type soundCardFunc func()
+
+func (f soundCardFunc) Play(ctx context.Context, audioFileName string, volume int, gainDB float64) error {
+	f()
+	return nil
+}
+
+func (f soundCardFunc) Stop() bool {
+	return false
+}
@@ -0,0 +1,107 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// listenForTriggers listens for D-Bus signals on the given interfaces, for
+// example "org.cacophony.thermalrecorder", and sends the name of each signal
+// as a trigger.
+func listenForTriggers(interfaces []string) (<-chan string, error) {
+	triggers := make(chan string)
+	if len(interfaces) == 0 {
+		return triggers, nil
+	}
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range interfaces {
+		rule := fmt.Sprintf("type='signal',interface='%s'", iface)
+		if call := conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule); call.Err != nil {
+			return nil, fmt.Errorf("could not listen for signals on %s: %v", iface, call.Err)
+		}
+	}
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+	go forwardTriggers(signals, interfaces, triggers)
+	return triggers, nil
+}
+
+// forwardTriggers sends the name of each signal on the interfaces as a trigger.
+// Triggers are dropped if nothing is waiting for one.
+func forwardTriggers(signals <-chan *dbus.Signal, interfaces []string, triggers chan<- string) {
+	for sig := range signals {
+		i := strings.LastIndex(sig.Name, ".")
+		if i < 0 || !contains(interfaces, sig.Name[:i]) {
+			continue
+		}
+		trigger := sig.Name[i+1:]
+		select {
+		case triggers <- trigger:
+			log.Printf("'%s' trigger fired", trigger)
+		default:
+		}
+	}
+}
+
+// cooldowns records when each trigger last played a combo from the schedule, so
+// it isn't played again until the combo's Every has passed. A nil cooldowns
+// doesn't hold triggers back.
+type cooldowns struct {
+	mu         sync.Mutex
+	lastPlayed map[string]time.Time
+}
+
+func newCooldowns() *cooldowns {
+	return &cooldowns{lastPlayed: make(map[string]time.Time)}
+}
+
+// start records that the trigger is playing its combo now, unless it played it
+// less than cooldown ago, in which case the reason it can't be played is returned.
+func (c *cooldowns) start(trigger string, now time.Time, cooldown time.Duration) string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, played := c.lastPlayed[trigger]; played && now.Sub(last) < cooldown {
+		return fmt.Sprintf("'%s' trigger played %s ago", trigger, now.Sub(last))
+	}
+	c.lastPlayed[trigger] = now
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardTriggers(t *testing.T) {
+	signals := make(chan *dbus.Signal)
+	// There is room for one trigger waiting to be received.
+	triggers := make(chan string, 1)
+	go forwardTriggers(signals, []string{"org.cacophony.thermalrecorder"}, triggers)
+	// Once another signal has been taken the one before it has been forwarded.
+	sync := func() { signals <- &dbus.Signal{Name: "org.cacophony.other.Sync"} }
+
+	signals <- &dbus.Signal{Name: "org.cacophony.other.Tracking"}
+	signals <- &dbus.Signal{Name: "org.cacophony.thermalrecorder.Tracking"}
+	sync()
+	assert.Equal(t, "Tracking", <-triggers)
+
+	// Triggers are dropped instead of blocking when nothing is ready for them.
+	signals <- &dbus.Signal{Name: "org.cacophony.thermalrecorder.Tracking"}
+	signals <- &dbus.Signal{Name: "org.cacophony.thermalrecorder.Person"}
+	sync()
+	assert.Equal(t, "Tracking", <-triggers)
+	assert.Empty(t, triggers)
+	close(signals)
+}
@@ -1,6 +1,6 @@
 module github.com/TheCacophonyProject/audiobait/v3
 
-go 1.12
+go 1.13
 
 require (
 	github.com/TheCacophonyProject/event-reporter v1.3.2-0.20200210010421-ca3fcb76a231
@@ -10,7 +10,9 @@ require (
 	github.com/TheCacophonyProject/window v0.0.0-20190821235241-ab92c2ee24b6
 	github.com/alexflint/go-arg v1.1.0
 	github.com/godbus/dbus v4.1.0+incompatible
+	github.com/hajimehoshi/go-mp3 v0.3.4
+	github.com/jfreymuth/oggvorbis v1.0.5
+	github.com/nathan-osman/go-sunrise v0.0.0-20171121204956-7c449e7c690b
 	github.com/stretchr/testify v1.4.0
-	golang.org/x/sys v0.0.0-20190912141932-bc967efca4b8 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 )
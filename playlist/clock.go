@@ -0,0 +1,247 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package playlist
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock models a clock.   That has been abstracted for unit testing.
+// Waiting is done through channels so that it can be combined with other
+// events in a select.
+type Clock interface {
+	// Now gets the current time
+	Now() time.Time
+	// After sends the current time on the returned channel once the duration has passed.
+	After(d time.Duration) <-chan time.Time
+	// NewTimer creates a timer that sends the current time on its channel once the duration has passed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event timer created by a Clock, like time.Timer.
+type Timer interface {
+	// C is the channel the time is sent on when the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. It returns false if the timer had already fired or been stopped.
+	Stop() bool
+	// Reset changes the timer to fire after the duration. It returns true if the timer had been active.
+	Reset(d time.Duration) bool
+}
+
+// Wait waits for the duration on the clock, returning the context's error
+// if the context is cancelled before then.
+func Wait(ctx context.Context, clock Clock, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ActualClock uses the standard go time.
+type ActualClock struct{}
+
+func (t *ActualClock) Now() time.Time {
+	return time.Now()
+}
+
+func (t *ActualClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (t *ActualClock) NewTimer(d time.Duration) Timer {
+	return actualTimer{time.NewTimer(d)}
+}
+
+type actualTimer struct {
+	*time.Timer
+}
+
+func (t actualTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// FakeClock is a Clock for tests. Time only moves when Advance or
+// AdvanceToNext is called, so runs lasting many days can be simulated
+// quickly and deterministically.
+type FakeClock struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	timers  []*fakeTimer
+}
+
+// NewFakeClock creates a fake clock starting at the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	c.schedule(t, d)
+	return t
+}
+
+// Advance moves the time forward, firing the timers that become due in order.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].deadline.After(end) {
+		c.fire(c.timers[0])
+	}
+	c.now = end
+}
+
+// AdvanceToNext moves the time forward to when the next timer is due and fires it.
+// It returns false if there are no timers waiting.
+func (c *FakeClock) AdvanceToNext() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.timers) == 0 {
+		return false
+	}
+	c.fire(c.timers[0])
+	return true
+}
+
+// BlockUntil waits until at least n timers are waiting to fire. Use it to
+// make sure another goroutine is waiting on the clock before advancing it.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+}
+
+// BlockUntilDeadline waits until a timer is waiting to fire at the given time.
+// Use it when the timer it is waiting for might replace one that is already waiting.
+func (c *FakeClock) BlockUntilDeadline(deadline time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for !c.hasDeadline(deadline) {
+		c.changed.Wait()
+	}
+}
+
+func (c *FakeClock) hasDeadline(deadline time.Time) bool {
+	for _, t := range c.timers {
+		if t.deadline.Equal(deadline) {
+			return true
+		}
+	}
+	return false
+}
+
+// Waiters returns how many timers are waiting to fire.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// schedule adds the timer to the waiting timers, or fires it straight away if
+// the duration isn't positive. c.mu must be held.
+func (c *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	t.deadline = c.now.Add(d)
+	if d <= 0 {
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		return
+	}
+	c.timers = append(c.timers, t)
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].deadline.Before(c.timers[j].deadline)
+	})
+	c.changed.Broadcast()
+}
+
+// fire moves the time to the timer's deadline and sends it. c.mu must be held.
+func (c *FakeClock) fire(t *fakeTimer) {
+	c.remove(t)
+	if t.deadline.After(c.now) {
+		c.now = t.deadline
+	}
+	select {
+	case t.c <- c.now:
+	default:
+	}
+}
+
+// remove takes the timer out of the waiting timers, returning whether it was waiting. c.mu must be held.
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, waiting := range c.timers {
+		if waiting == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			c.changed.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.remove(t)
+	t.clock.schedule(t, d)
+	return active
+}
@@ -0,0 +1,71 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package playlist
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var clockStart = time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+func TestFakeClockFiresTimersInOrder(t *testing.T) {
+	clock := NewFakeClock(clockStart)
+	later := clock.After(2 * time.Hour)
+	sooner := clock.After(time.Hour)
+
+	clock.Advance(90 * time.Minute)
+	assert.Equal(t, clockStart.Add(time.Hour), <-sooner)
+	assert.Equal(t, clockStart.Add(90*time.Minute), clock.Now())
+	assert.Equal(t, 1, clock.Waiters())
+
+	assert.True(t, clock.AdvanceToNext())
+	assert.Equal(t, clockStart.Add(2*time.Hour), <-later)
+	assert.False(t, clock.AdvanceToNext())
+}
+
+func TestFakeClockTimerStopAndReset(t *testing.T) {
+	clock := NewFakeClock(clockStart)
+	timer := clock.NewTimer(time.Minute)
+	assert.True(t, timer.Stop())
+	assert.False(t, timer.Stop())
+	assert.Equal(t, 0, clock.Waiters())
+
+	assert.False(t, timer.Reset(time.Hour))
+	clock.Advance(time.Hour)
+	assert.Equal(t, clockStart.Add(time.Hour), <-timer.C())
+}
+
+func TestWaitCanBeCancelledOnFakeClock(t *testing.T) {
+	clock := NewFakeClock(clockStart)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Wait(ctx, clock, time.Hour)
+	}()
+
+	clock.BlockUntil(1)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, clockStart, clock.Now())
+	assert.Equal(t, 0, clock.Waiters())
+}
@@ -0,0 +1,198 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package playlist
+
+import (
+	"encoding/json"
+	"math/rand"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Experimental designs for assigning play and control nights.
+const (
+	// DesignAlternating plays for PlayNights then is silent for ControlNights.
+	DesignAlternating = "alternating"
+	// DesignRandomBlock splits the nights into blocks of PlayNights+ControlNights
+	// and assigns the play nights at random within each block, using Seed.
+	DesignRandomBlock = "randomBlock"
+)
+
+// Date is a calendar date, written as "2006-01-02" in JSON.
+type Date struct {
+	time.Time
+}
+
+func (date *Date) UnmarshalJSON(bValue []byte) (err error) {
+	if string(bValue) == "null" {
+		date.Time = time.Time{}
+		return
+	}
+	var s string
+	if err = json.Unmarshal(bValue, &s); err != nil {
+		return
+	}
+	date.Time, err = time.Parse(dateLayout, s)
+	return
+}
+
+func (date *Date) MarshalJSON() ([]byte, error) {
+	if date.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(date.Format(dateLayout))
+}
+
+func NewDate(year int, month time.Month, day int) Date {
+	return Date{Time: time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
+
+// CalendarNight says whether the audiobait day starting on Date is a play or control night.
+type CalendarNight struct {
+	Date       Date
+	Design     string
+	Block      int
+	DayOfCycle int
+	// Seed is the schedule's seed when the night was assigned at random.
+	Seed int64
+	Play bool
+}
+
+// daysBetween counts the whole calendar days from the date of a to the date of b.
+func daysBetween(a, b time.Time) int {
+	aDate := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	bDate := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(bDate.Sub(aDate).Hours() / 24)
+}
+
+// cycleStart returns the date the play/control cycle is anchored to. Schedules
+// without a CycleStart fall back to the legacy StartDay of day's month.
+func (schedule *Schedule) cycleStart(day time.Time) time.Time {
+	if !schedule.CycleStart.IsZero() {
+		return schedule.CycleStart.Time
+	}
+	return schedule.legacyCycleStart(day)
+}
+
+// legacyCycleStart returns the StartDay of day's month. A StartDay past the
+// end of a short month is taken as the last day of that month.
+func (schedule *Schedule) legacyCycleStart(day time.Time) time.Time {
+	firstDay := schedule.StartDay
+	if firstDay < 1 {
+		firstDay = 1
+	}
+	if lastDay := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day(); firstDay > lastDay {
+		firstDay = lastDay
+	}
+	return time.Date(day.Year(), day.Month(), firstDay, 0, 0, 0, 0, time.UTC)
+}
+
+// night works out where the audiobait day starting on day falls in the
+// play/control cycle, counting whole days elapsed since the cycle start.
+func (schedule *Schedule) night(day time.Time) CalendarNight {
+	cycle := schedule.CycleLength()
+	days := daysBetween(schedule.cycleStart(day), day)
+	block := days / cycle
+	if days < 0 && days%cycle != 0 {
+		block--
+	}
+	night := CalendarNight{
+		Date:       NewDate(day.Year(), day.Month(), day.Day()),
+		Design:     schedule.design(),
+		Block:      block,
+		DayOfCycle: days - block*cycle,
+	}
+
+	switch {
+	case schedule.ControlNights < 1:
+		night.Play = true
+	case night.Design == DesignRandomBlock:
+		night.Seed = schedule.Seed
+		night.Play = schedule.blockAssignment(block)[night.DayOfCycle]
+	default:
+		night.Play = night.DayOfCycle < schedule.PlayNights
+	}
+	return night
+}
+
+// blockAssignment randomly orders the play and control nights of a block.
+// Night i of the block is a play night if rand.New(rand.NewSource(Seed + block)).Perm(CycleLength())[i]
+// is less than PlayNights, so the design can be reproduced from the seed.
+func (schedule *Schedule) blockAssignment(block int) []bool {
+	r := rand.New(rand.NewSource(schedule.Seed + int64(block)))
+	assignment := make([]bool, schedule.CycleLength())
+	for i, n := range r.Perm(len(assignment)) {
+		assignment[i] = n < schedule.PlayNights
+	}
+	return assignment
+}
+
+func (schedule *Schedule) design() string {
+	if schedule.Design == "" {
+		return DesignAlternating
+	}
+	return schedule.Design
+}
+
+// Calendar lists whether each audiobait day, starting on the dates from
+// from to to inclusive, is a play or a control night.
+func (schedule *Schedule) Calendar(from, to time.Time) []CalendarNight {
+	var nights []CalendarNight
+	for day := from; daysBetween(day, to) >= 0; day = addDays(day, 1) {
+		nights = append(nights, schedule.night(day))
+	}
+	return nights
+}
+
+// CalendarFrom lists whether each of the given number of audiobait days, starting
+// with the one at from, is a play or a control night. Days change over at the
+// schedule's day changeover, which is resolved for the location.
+func CalendarFrom(schedule Schedule, from time.Time, days int, location Location) []CalendarNight {
+	start, _ := dayBounds(from, schedule.dayChangeover(), location)
+	return schedule.Calendar(start, addDays(start, days-1))
+}
+
+// migrateCycleStart anchors a schedule that only has the legacy StartDay to an
+// absolute date, chosen so that the play/control night of the audiobait day
+// starting on day doesn't change. The anchor is carried over from the previous
+// schedule when that was migrated from the same StartDay, so repeated
+// downloads don't move it.
+func (schedule *Schedule) migrateCycleStart(previous *Schedule, day time.Time) {
+	if !schedule.CycleStart.IsZero() {
+		return
+	}
+	if previous != nil && !previous.CycleStart.IsZero() && previous.StartDay == schedule.StartDay {
+		schedule.CycleStart = previous.CycleStart
+		return
+	}
+	schedule.CycleStart = Date{Time: schedule.legacyCycleStart(day)}
+}
+
+// dayStart returns when the audiobait day containing t started. The device's
+// location isn't known here so days changing over relative to the sun are
+// taken to change over at the default time instead.
+func (schedule *Schedule) dayStart(t time.Time) time.Time {
+	changeover := schedule.dayChangeover()
+	if changeover.SunEvent != "" {
+		changeover = *NewTimeOfDay(defaultDayChangeover)
+	}
+	start, _ := dayBounds(t, changeover, Location{})
+	return start
+}
@@ -0,0 +1,193 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package playlist
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCycleContinuesOverMonthBoundary(t *testing.T) {
+	schedule := Schedule{
+		PlayNights:    3,
+		ControlNights: 4,
+		CycleStart:    NewDate(1, time.March, 29),
+		Combos:        []Combo{createCombo("19:00", "20:00", 60, "foo")},
+	}
+	schedulePlayer, clock := createPlayer("17:01")
+
+	checkPlaysOn(29, time.March, t, schedule, schedulePlayer, clock)
+	checkPlaysOn(31, time.March, t, schedule, schedulePlayer, clock)
+	checkSilentOn(1, time.April, t, schedule, schedulePlayer, clock)
+	checkSilentOn(4, time.April, t, schedule, schedulePlayer, clock)
+	checkPlaysOn(5, time.April, t, schedule, schedulePlayer, clock)
+	checkPlaysOn(7, time.April, t, schedule, schedulePlayer, clock)
+	checkSilentOn(8, time.April, t, schedule, schedulePlayer, clock)
+	checkPlaysOn(22, time.March, t, schedule, schedulePlayer, clock)
+	checkSilentOn(21, time.March, t, schedule, schedulePlayer, clock)
+}
+
+func TestCalendar(t *testing.T) {
+	schedule := Schedule{
+		PlayNights:    2,
+		ControlNights: 1,
+		CycleStart:    NewDate(2021, time.January, 30),
+	}
+	nights := schedule.Calendar(
+		time.Date(2021, time.January, 30, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, time.February, 3, 0, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, []CalendarNight{
+		{Date: NewDate(2021, time.January, 30), Design: DesignAlternating, Block: 0, DayOfCycle: 0, Play: true},
+		{Date: NewDate(2021, time.January, 31), Design: DesignAlternating, Block: 0, DayOfCycle: 1, Play: true},
+		{Date: NewDate(2021, time.February, 1), Design: DesignAlternating, Block: 0, DayOfCycle: 2, Play: false},
+		{Date: NewDate(2021, time.February, 2), Design: DesignAlternating, Block: 1, DayOfCycle: 0, Play: true},
+		{Date: NewDate(2021, time.February, 3), Design: DesignAlternating, Block: 1, DayOfCycle: 1, Play: true},
+	}, nights)
+}
+
+func TestRandomBlockDesign(t *testing.T) {
+	schedule := Schedule{
+		PlayNights:    3,
+		ControlNights: 3,
+		Design:        DesignRandomBlock,
+		Seed:          42,
+		CycleStart:    NewDate(2021, time.January, 1),
+	}
+	from := time.Date(2020, time.December, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, time.March, 31, 0, 0, 0, 0, time.UTC)
+	nights := schedule.Calendar(from, to)
+
+	// Every whole block has three play nights.
+	plays := make(map[int]int)
+	for _, night := range nights {
+		assert.Equal(t, int64(42), night.Seed)
+		if night.Play {
+			plays[night.Block]++
+		}
+	}
+	for block := -4; block < 14; block++ {
+		assert.Equal(t, 3, plays[block], "block %d", block)
+	}
+
+	// The same seed reproduces the design and a different seed changes it.
+	assert.Equal(t, nights, schedule.Calendar(from, to))
+	schedule.Seed = 43
+	assert.NotEqual(t, nights, schedule.Calendar(from, to))
+}
+
+func TestRandomBlockNightIsRecorded(t *testing.T) {
+	schedule := Schedule{
+		PlayNights:    1,
+		ControlNights: 1,
+		Design:        DesignRandomBlock,
+		CycleStart:    NewDate(1, time.April, 1),
+		Combos:        []Combo{createCombo("19:00", "20:00", 60, "foo")},
+	}
+	schedulePlayer, clock := createPlayer("17:01")
+	clock.SetDay(3, time.April)
+	schedulePlayer.PlayTodaysSchedule(context.Background(), schedule)
+
+	assert.Len(t, clock.Nights, 1)
+	assert.Equal(t, NewDate(1, time.April, 3), clock.Nights[0].Date)
+	assert.Equal(t, 1, clock.Nights[0].Block)
+	assert.Equal(t, schedulePlayer.IsSoundPlayingDay(schedule), clock.Nights[0].Play)
+}
+
+func TestMigrateCycleStart(t *testing.T) {
+	now := time.Date(2021, time.April, 10, 15, 0, 0, 0, time.UTC)
+
+	schedule := Schedule{PlayNights: 2, ControlNights: 5, StartDay: 3}
+	wasPlayNight := schedule.night(now).Play
+	schedule.migrateCycleStart(nil, now)
+	assert.Equal(t, NewDate(2021, time.April, 3), schedule.CycleStart)
+	assert.Equal(t, wasPlayNight, schedule.night(now).Play)
+
+	// The anchor isn't moved by a later download of the same schedule.
+	later := Schedule{PlayNights: 2, ControlNights: 5, StartDay: 3}
+	later.migrateCycleStart(&schedule, now.AddDate(0, 1, 0))
+	assert.Equal(t, NewDate(2021, time.April, 3), later.CycleStart)
+
+	// An explicit cycle start is left alone.
+	explicit := Schedule{StartDay: 3, CycleStart: NewDate(2020, time.December, 25)}
+	explicit.migrateCycleStart(&schedule, now)
+	assert.Equal(t, NewDate(2020, time.December, 25), explicit.CycleStart)
+}
+
+func TestCalendarFrom(t *testing.T) {
+	schedule := Schedule{PlayNights: 1, ControlNights: 2, CycleStart: NewDate(2021, time.April, 1)}
+
+	// Before the midday changeover it is still the previous audiobait day.
+	nights := CalendarFrom(schedule, time.Date(2021, time.April, 3, 9, 0, 0, 0, time.UTC), 3, Location{})
+	assert.Len(t, nights, 3)
+	assert.Equal(t, NewDate(2021, time.April, 2), nights[0].Date)
+	assert.Equal(t, []bool{false, false, true}, []bool{nights[0].Play, nights[1].Play, nights[2].Play})
+}
+
+func TestLegacyScheduleOnDiskKeepsItsCycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiobait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	raw := `{"playNights": 2, "controlNights": 5, "startDay": 31, "allsounds": [1],
+		"combos": [{"from": "19:00", "until": "20:00", "every": 60, "sounds": ["1"], "waits": [0], "volumes": [5]}]}`
+	filename := filepath.Join(dir, ScheduleFilename)
+	require.NoError(t, ioutil.WriteFile(filename, []byte(raw), 0644))
+	saved := time.Date(2021, time.February, 10, 15, 0, 0, 0, time.Local)
+	require.NoError(t, os.Chtimes(filename, saved, saved))
+
+	// A StartDay of 31 is the last day of February rather than the 3rd of March.
+	schedule, err := LoadScheduleFromDisk(dir)
+	require.NoError(t, err)
+	assert.Equal(t, NewDate(2021, time.February, 28), schedule.CycleStart)
+
+	// The cycle carries on into the next month instead of starting again.
+	nights := schedule.Calendar(time.Date(2021, time.February, 28, 0, 0, 0, 0, time.UTC), time.Date(2021, time.March, 31, 0, 0, 0, 0, time.UTC))
+	for i, night := range nights {
+		assert.Equal(t, i%7, night.DayOfCycle, night.Date.String())
+	}
+
+	// Saving the same schedule again writes the anchor to disk.
+	legacy, err := bytesToSchedule([]byte(raw))
+	require.NoError(t, err)
+	isNew, err := SaveScheduleIfNew(dir, legacy)
+	require.NoError(t, err)
+	assert.True(t, isNew)
+	onDisk, err := ReadSchedule(dir)
+	require.NoError(t, err)
+	assert.Equal(t, NewDate(2021, time.February, 28), onDisk.CycleStart)
+}
+
+func TestDateJson(t *testing.T) {
+	var schedule Schedule
+	assert.NoError(t, json.Unmarshal([]byte(`{"cycleStart": "2021-03-29"}`), &schedule))
+	assert.Equal(t, NewDate(2021, time.March, 29), schedule.CycleStart)
+
+	raw, err := json.Marshal(&schedule.CycleStart)
+	assert.NoError(t, err)
+	assert.Equal(t, `"2021-03-29"`, string(raw))
+}
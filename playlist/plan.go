@@ -0,0 +1,147 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package playlist
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// PlannedPlay is a sound that a schedule will play, or the start of a control
+// night where it won't play any.
+type PlannedPlay struct {
+	Timestamp time.Time
+	// Combo is the index of the combo the sound is from.
+	Combo int
+	// Choice is the sound as given in the combo, a file ID, "random" or "same".
+	Choice string
+	// FileID is the file that will be played, or 0 if it is chosen at random
+	// when the sound is played.
+	FileID int
+	Volume int
+	// Control is true for the start of a control night. Only Timestamp is set.
+	Control bool
+}
+
+// Plan works out every sound the schedule will play from from until to, in
+// order, marking the start of each control night. The plan is made by running
+// a SchedulePlayer on a clock that doesn't wait, so it follows exactly the
+// same timing as the schedule being played. Combos that are played by a trigger
+// can't be planned so aren't included.
+func Plan(schedule Schedule, allSounds map[int]string, from, to time.Time, location Location) []PlannedPlay {
+	if len(schedule.Combos) < 1 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &planClock{now: from, end: to, cancel: cancel}
+
+	sp := NewPlayer(allSounds, "")
+	sp.SetClock(clock)
+	sp.SetLocation(location)
+	p := &plan{}
+	sp.plan = p
+
+	var lastControlNight Date
+	for ctx.Err() == nil {
+		if night := sp.tonight(schedule); !night.Play && night.Date != lastControlNight {
+			lastControlNight = night.Date
+			start := sp.todayStart(schedule)
+			if start.Before(from) {
+				start = from
+			}
+			p.plays = append(p.plays, PlannedPlay{Timestamp: start, Control: true})
+		}
+		if err := Wait(ctx, clock, sp.TimeUntilNextCombo(schedule)); err != nil {
+			break
+		}
+		sp.PlayTodaysSchedule(ctx, schedule)
+	}
+	return p.plays
+}
+
+// plan collects the sounds a SchedulePlayer would have played.
+type plan struct {
+	plays []PlannedPlay
+	// combo is the index of the combo being played.
+	combo int
+	// previous is the file chosen for the last sound of the combo, or 0 if it was random.
+	previous int
+}
+
+// startCombo is called before the player plays a combo.
+func (p *plan) startCombo(index int) {
+	p.combo = index
+	p.previous = 0
+}
+
+// add records a sound that would have been played.
+func (p *plan) add(ts time.Time, choice string, volume int) {
+	switch choice {
+	case "random":
+		p.previous = 0
+	case "same":
+	default:
+		p.previous, _ = strconv.Atoi(choice)
+	}
+	p.plays = append(p.plays, PlannedPlay{
+		Timestamp: ts,
+		Combo:     p.combo,
+		Choice:    choice,
+		FileID:    p.previous,
+		Volume:    volume,
+	})
+}
+
+// planClock is a clock that moves straight to the end of each wait. It
+// cancels the plan instead of waiting past the end of the plan.
+type planClock struct {
+	now    time.Time
+	end    time.Time
+	cancel context.CancelFunc
+}
+
+func (c *planClock) Now() time.Time {
+	return c.now
+}
+
+func (c *planClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *planClock) NewTimer(d time.Duration) Timer {
+	timer := planTimer(make(chan time.Time, 1))
+	if c.now.Add(d).After(c.end) {
+		c.now = c.end
+		c.cancel()
+		return timer
+	}
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	timer <- c.now
+	return timer
+}
+
+type planTimer chan time.Time
+
+func (t planTimer) C() <-chan time.Time        { return t }
+func (t planTimer) Stop() bool                 { return false }
+func (t planTimer) Reset(d time.Duration) bool { return false }
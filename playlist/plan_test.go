@@ -0,0 +1,114 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package playlist
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/event-reporter/eventclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func planSchedule() Schedule {
+	return Schedule{
+		PlayNights:    1,
+		ControlNights: 1,
+		CycleStart:    NewDate(2019, time.January, 1),
+		Combos: []Combo{
+			{
+				From:    *NewTimeOfDay("19:00"),
+				Every:   600,
+				Until:   *NewTimeOfDay("19:25"),
+				Waits:   []int{0, 5},
+				Volumes: []int{5, 6},
+				Sounds:  []string{"3", "same"},
+			},
+			{
+				From:    *NewTimeOfDay("21:00"),
+				Every:   600,
+				Until:   *NewTimeOfDay("21:05"),
+				Waits:   []int{0},
+				Volumes: []int{10},
+				Sounds:  []string{"random"},
+			},
+		},
+		AllSounds: []int{1, 3, 4},
+	}
+}
+
+func TestPlan(t *testing.T) {
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2019, time.January, day, hour, minute, second, 0, time.UTC)
+	}
+	plays := Plan(planSchedule(), soundFiles, at(1, 13, 0, 0), at(3, 19, 10, 0), Location{})
+
+	assert.Equal(t, []PlannedPlay{
+		{Timestamp: at(1, 19, 0, 0), Combo: 0, Choice: "3", FileID: 3, Volume: 5},
+		{Timestamp: at(1, 19, 0, 5), Combo: 0, Choice: "same", FileID: 3, Volume: 6},
+		{Timestamp: at(1, 19, 10, 0), Combo: 0, Choice: "3", FileID: 3, Volume: 5},
+		{Timestamp: at(1, 19, 10, 5), Combo: 0, Choice: "same", FileID: 3, Volume: 6},
+		{Timestamp: at(1, 19, 20, 0), Combo: 0, Choice: "3", FileID: 3, Volume: 5},
+		{Timestamp: at(1, 19, 20, 5), Combo: 0, Choice: "same", FileID: 3, Volume: 6},
+		{Timestamp: at(1, 21, 0, 0), Combo: 1, Choice: "random", FileID: 0, Volume: 10},
+		{Timestamp: at(2, 12, 0, 0), Control: true},
+		{Timestamp: at(3, 19, 0, 0), Combo: 0, Choice: "3", FileID: 3, Volume: 5},
+		{Timestamp: at(3, 19, 0, 5), Combo: 0, Choice: "same", FileID: 3, Volume: 6},
+		{Timestamp: at(3, 19, 10, 0), Combo: 0, Choice: "3", FileID: 3, Volume: 5},
+	}, plays)
+}
+
+func TestPlanStartingOnControlNight(t *testing.T) {
+	from := time.Date(2019, time.January, 2, 18, 0, 0, 0, time.UTC)
+	plays := Plan(planSchedule(), soundFiles, from, from.Add(time.Hour), Location{})
+	assert.Equal(t, []PlannedPlay{{Timestamp: from, Control: true}}, plays)
+}
+
+func TestPlanMatchesPlayer(t *testing.T) {
+	audiobaitclientPlay =
+		func(int, int, int, *eventclient.Event) (bool, string, error) {
+			return true, "", nil
+		}
+	schedule := planSchedule()
+	from := time.Date(2019, time.January, 1, 20, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(from)
+	recorder := new(TestClockAndAudioDevice)
+	schedulePlayer := NewPlayer(soundFiles, "")
+	schedulePlayer.SetClock(clock)
+	schedulePlayer.SetRecorder(recorder)
+
+	var played []time.Time
+	runOnFakeClock(clock, func() {
+		for len(recorder.Nights) < 5 {
+			ctx := context.Background()
+			Wait(ctx, clock, schedulePlayer.TimeUntilNextCombo(schedule))
+			schedulePlayer.PlayTodaysSchedule(ctx, schedule)
+		}
+		played = recorder.playedAt
+	})
+
+	var planned []time.Time
+	for _, play := range Plan(schedule, soundFiles, from, clock.Now(), Location{}) {
+		if !play.Control {
+			planned = append(planned, play.Timestamp)
+		}
+	}
+	assert.Equal(t, played, planned)
+}
@@ -19,6 +19,7 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package playlist
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -28,42 +29,42 @@ import (
 )
 
 // Can be mocked for testing
-var audiobaitclientPlay = audiobaitclient.PlayFromId
+var audiobaitclientPlay = audiobaitclient.PlayFromIdWithReason
 
 type Player struct{}
 
-// Clock models a clock.   That has been abstracted for unit testing.
-type Clock interface {
-	// Now gets the current time
-	Now() time.Time
-	// Wait does a synchronous wait for the given time duration.
-	Wait(time.Duration)
-}
-
 // SoundPlayedRecorder gets a notification when a sound has been played.
 type SoundPlayedRecorder interface {
 	// OnBaitPlayed is called when the device believes audiobait has been played
 	OnAudioBaitPlayed(ts time.Time, fileId int, volume int)
 }
 
-// ActualClock uses the standard go time.
-type ActualClock struct{}
-
-func (t *ActualClock) Now() time.Time {
-	return time.Now()
-}
-
-func (t *ActualClock) Wait(duration time.Duration) {
-	time.Sleep(duration)
+// EventRecorder gets notifications about everything the schedule player does, so that
+// a night with no plays can be told apart from a device that isn't working.
+type EventRecorder interface {
+	SoundPlayedRecorder
+	// OnPlayNightStart is called when the player starts a night where sounds will be played.
+	OnPlayNightStart(ts time.Time, schedule Schedule, night CalendarNight)
+	// OnControlNightStart is called when the player starts a night where no sounds will be played.
+	OnControlNightStart(ts time.Time, schedule Schedule, night CalendarNight)
+	// OnComboSkipped is called when a combo finishes without any sounds being played.
+	OnComboSkipped(ts time.Time, comboIndex int, reason string)
+	// OnPlayFailed is called when a sound in a combo could not be played.
+	OnPlayFailed(ts time.Time, fileId int, volume int, reason string)
 }
 
 // SchedulePlayer takes a schedule and a bunch of audio files and plays them at the times specified on the schedule.
 type SchedulePlayer struct {
 	time     Clock
-	recorder SoundPlayedRecorder
+	recorder EventRecorder
 	// allSounds is a map of audio file ID to name of audio file on disk
 	allSounds map[int]string
 	filesDir  string
+	location  Location
+	// plan collects the sounds instead of playing them when the player is making a Plan.
+	plan *plan
+	// triggers receives the names of triggers as they fire.
+	triggers <-chan string
 }
 
 // NewPlayer creates a new schedule player.
@@ -79,30 +80,59 @@ func newSchedulePlayerWithClock(
 	return &SchedulePlayer{time: clock, allSounds: allSoundsMap, filesDir: filesDirectory}
 }
 
-// nextDayStart calculates when the next audiobait day starts (typically around midday).
-func nextDayStart(now time.Time) time.Time {
-	// start hour and minute today
-	todayChangeOverTime := time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, now.Location())
+// dayBounds calculates when the current audiobait day started and when the next one starts.
+// Days change over at the given time of day, which can be relative to the sun.
+func dayBounds(now time.Time, changeover TimeOfDay, location Location) (start, next time.Time) {
+	todayChangeOverTime := resolveOn(changeover, now, location)
 
-	// If it is before now then next start of day must be 24 hours later.
-	if now.After(todayChangeOverTime) {
-		return todayChangeOverTime.Add(24 * time.Hour)
-	} else {
-		return todayChangeOverTime
+	// If it is now or earlier then the next start of day is tomorrow.
+	if !now.Before(todayChangeOverTime) {
+		return todayChangeOverTime, resolveOn(changeover, addDays(now, 1), location)
 	}
+	return resolveOn(changeover, addDays(now, -1), location), todayChangeOverTime
+}
+
+// addDays moves the date of t by the given number of days, keeping the clock time.
+func addDays(t time.Time, days int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
 }
 
 func (sp SchedulePlayer) TimeUntilNextCombo(schedule Schedule) time.Duration {
 	combos := schedule.Combos
 
 	if !sp.IsSoundPlayingDay(schedule) {
-		return 12 * time.Hour
+		return sp.nextDayStart(schedule).Sub(sp.time.Now())
 	}
 
 	i := sp.findNextCombo(combos)
 	return sp.createWindow(combos[i]).Until()
 }
 
+// NextCombo works out which combo is playing, or is the next one to play, and when
+// its window started or will start. Active is true if its window has started.
+// The schedule must have at least one combo.
+func (sp SchedulePlayer) NextCombo(schedule Schedule) (index int, start time.Time, active bool) {
+	index = sp.findNextCombo(schedule.Combos)
+	win := sp.createWindow(schedule.Combos[index])
+	if win.Active() {
+		return index, win.PreviousStart(), true
+	}
+	return index, win.NextStart(), false
+}
+
+// ComboPlayable works out whether a combo of the schedule can be played now.
+// Combos are only played on play nights and during their window. If the combo
+// can't be played the reason is returned.
+func (sp SchedulePlayer) ComboPlayable(schedule Schedule, index int) (bool, string) {
+	if !sp.IsSoundPlayingDay(schedule) {
+		return false, "tonight is a control night"
+	}
+	if !sp.createWindow(schedule.Combos[index]).Active() {
+		return false, "outside the combo's window"
+	}
+	return true, ""
+}
+
 // findNextCombo takes and array of schedule combos and works out which one
 // is the next one to be played (or is currently playing)
 // Returns array position
@@ -122,11 +152,28 @@ func (sp SchedulePlayer) findNextCombo(combos []Combo) int {
 	return nextIndex
 }
 
-// SetRecorder sets the call back that records when a sound has successfully played
-func (sp *SchedulePlayer) SetRecorder(recorder SoundPlayedRecorder) {
+// SetClock sets the clock the player uses to decide when to play sounds.
+func (sp *SchedulePlayer) SetClock(clock Clock) {
+	sp.time = clock
+}
+
+// SetLocation sets where the device is, for resolving times relative to sunrise and sunset.
+func (sp *SchedulePlayer) SetLocation(location Location) {
+	sp.location = location
+}
+
+// SetRecorder sets the call backs that record what the player does
+func (sp *SchedulePlayer) SetRecorder(recorder EventRecorder) {
 	sp.recorder = recorder
 }
 
+// SetTriggers sets where the player hears about triggers firing, for combos that
+// are played by a trigger.  Triggers that fire when the player isn't waiting for
+// one should be dropped rather than queued.
+func (sp *SchedulePlayer) SetTriggers(triggers <-chan string) {
+	sp.triggers = triggers
+}
+
 // IsSoundPlayingDay works out whether sounds should be played today.
 // Having control days when we play no sound, helps to make sure that we canaccurately determine whether
 // sounds are attracting more animals or not.   They may also help stop animals getting
@@ -135,38 +182,41 @@ func (sp SchedulePlayer) IsSoundPlayingDay(schedule Schedule) bool {
 	if len(schedule.Combos) < 1 {
 		return false
 	}
-	if schedule.ControlNights < 1 {
-		return true
-	}
-
-	firstDay := schedule.StartDay
-	if firstDay < 1 {
-		firstDay = 1
-	}
-
-	todaysStart := sp.nextDayStart().Add(-24 * time.Hour)
-
-	dayOfCycle := (todaysStart.Day() - firstDay) % schedule.CycleLength()
-	if dayOfCycle < 0 {
-		dayOfCycle += schedule.CycleLength()
-	}
+	return sp.tonight(schedule).Play
+}
 
-	return dayOfCycle < schedule.PlayNights
+// tonight works out where the current audiobait day falls in the schedule's play/control cycle.
+func (sp SchedulePlayer) tonight(schedule Schedule) CalendarNight {
+	return schedule.night(sp.todayStart(schedule))
 }
 
-// PlayTodaysSchedule plays todays schedule or if it is a control day it waits until the start of the next day
-func (sp SchedulePlayer) PlayTodaysSchedule(schedule Schedule) {
-	if sp.IsSoundPlayingDay(schedule) {
+// PlayTodaysSchedule plays todays schedule or if it is a control day it waits until the start of the next day.
+// Cancelling the context stops the schedule between sounds and returns the context's error.
+func (sp SchedulePlayer) PlayTodaysSchedule(ctx context.Context, schedule Schedule) error {
+	if len(schedule.Combos) < 1 {
+		return nil
+	}
+	night := sp.tonight(schedule)
+	if night.Play {
 		log.Println("Today is an audiobait day.  Lets see what animals we can attract...")
-		sp.playTodaysCombos(schedule.Combos)
+		if sp.recorder != nil {
+			sp.recorder.OnPlayNightStart(sp.time.Now(), schedule, night)
+		}
+		return sp.playTodaysCombos(ctx, schedule)
 	}
+	log.Println("Today is a control day.  No sounds will be played")
+	if sp.recorder != nil {
+		sp.recorder.OnControlNightStart(sp.time.Now(), schedule, night)
+	}
+	return nil
 }
 
-// PlayTodaysCombos plays the given combos - doesn't not care whether it is a control day
-func (sp SchedulePlayer) playTodaysCombos(combos []Combo) {
+// PlayTodaysCombos plays the schedule's combos - doesn't not care whether it is a control day
+func (sp SchedulePlayer) playTodaysCombos(ctx context.Context, schedule Schedule) error {
+	combos := schedule.Combos
 	done := make(map[int]bool)
 
-	tomorrowStart := sp.nextDayStart()
+	tomorrowStart := sp.nextDayStart(schedule)
 	i := sp.findNextCombo(combos)
 
 	for len(done) < len(combos) {
@@ -176,7 +226,20 @@ func (sp SchedulePlayer) playTodaysCombos(combos []Combo) {
 			nextComboStart := sp.time.Now().Add(win.Until())
 			if nextComboStart.Before(tomorrowStart) {
 				log.Println("Playing combo...")
-				sp.playCombo(nextCombo)
+				if sp.plan != nil {
+					sp.plan.startCombo(i)
+				}
+				play, reason := sp.playCombo, "window ended before any sounds were played"
+				if nextCombo.Trigger != "" {
+					play, reason = sp.playTriggeredCombo, "no '"+nextCombo.Trigger+"' trigger during window"
+				}
+				bursts, err := play(ctx, nextCombo)
+				if err != nil {
+					return err
+				}
+				if bursts == 0 && sp.recorder != nil {
+					sp.recorder.OnComboSkipped(sp.time.Now(), i, reason)
+				}
 			} else {
 				done[i] = true
 			}
@@ -184,17 +247,25 @@ func (sp SchedulePlayer) playTodaysCombos(combos []Combo) {
 		i = (i + 1) % len(combos)
 	}
 	log.Println("Completed playing combos for today")
+	return nil
 }
 
 // nextDayStart works out when the next playing day starts. As the
-// playing day starts around midday, this could actually be later
-// today.
-func (sp SchedulePlayer) nextDayStart() time.Time {
-	return nextDayStart(sp.time.Now())
+// playing day starts at the schedule's day changeover (midday by default),
+// this could actually be later today.
+func (sp SchedulePlayer) nextDayStart(schedule Schedule) time.Time {
+	_, next := dayBounds(sp.time.Now(), schedule.dayChangeover(), sp.location)
+	return next
 }
 
-// playCombo plays a single combo
-func (sp SchedulePlayer) playCombo(combo Combo) {
+// todayStart works out when the current playing day started.
+func (sp SchedulePlayer) todayStart(schedule Schedule) time.Time {
+	start, _ := dayBounds(sp.time.Now(), schedule.dayChangeover(), sp.location)
+	return start
+}
+
+// playCombo plays a single combo, returning how many bursts of sounds were started
+func (sp SchedulePlayer) playCombo(ctx context.Context, combo Combo) (int, error) {
 	const startOfIntervalFuzzyFactor = 3 * time.Second
 	win := sp.createWindow(combo)
 	soundChooser := NewSoundChooser(sp.allSounds)
@@ -205,63 +276,161 @@ func (sp SchedulePlayer) playCombo(combo Combo) {
 	}
 	every = every * time.Second
 
+	bursts := 0
 	toWindow := win.Until()
 	if win.Until() > time.Duration(0) {
 		log.Printf("sleeping until next window (%s)", toWindow)
-		sp.time.Wait(toWindow)
-		sp.playSounds(combo, soundChooser)
+		if err := Wait(ctx, sp.time, toWindow); err != nil {
+			return bursts, err
+		}
+		bursts++
+		if err := sp.playSounds(ctx, combo, soundChooser, ""); err != nil {
+			return bursts, err
+		}
 	} else if win.UntilNextInterval(every) > every-startOfIntervalFuzzyFactor {
 		// If we have waited we might have missed the start by milliseconds
-		sp.playSounds(combo, soundChooser)
+		bursts++
+		if err := sp.playSounds(ctx, combo, soundChooser, ""); err != nil {
+			return bursts, err
+		}
 	}
 
 	for {
 		nextBurstSleep := win.UntilNextInterval(every)
 		if nextBurstSleep > time.Duration(-1) {
 			log.Print("Sleeping until next burst")
-			sp.time.Wait(nextBurstSleep)
-			sp.playSounds(combo, soundChooser)
+			if err := Wait(ctx, sp.time, nextBurstSleep); err != nil {
+				return bursts, err
+			}
+			bursts++
+			if err := sp.playSounds(ctx, combo, soundChooser, ""); err != nil {
+				return bursts, err
+			}
 		} else {
 			log.Print("Played last burst, sleeping until near end of window")
-			sp.time.Wait(win.UntilEnd())
-			return
+			return bursts, Wait(ctx, sp.time, win.UntilEnd())
+		}
+	}
+}
+
+// playTriggeredCombo arms a combo for its window, playing its sounds each time its
+// trigger fires. The combo isn't played again until Every seconds after it was
+// last played, so one detection can't cause repeated plays.
+// Returns how many bursts of sounds were started.
+func (sp SchedulePlayer) playTriggeredCombo(ctx context.Context, combo Combo) (int, error) {
+	win := sp.createWindow(combo)
+	if toWindow := win.Until(); toWindow > 0 {
+		log.Printf("sleeping until '%s' trigger window (%s)", combo.Trigger, toWindow)
+		if err := Wait(ctx, sp.time, toWindow); err != nil {
+			return 0, err
+		}
+	}
+
+	cooldown := time.Duration(combo.Every) * time.Second
+	soundChooser := NewSoundChooser(sp.allSounds)
+	windowEnd := sp.time.NewTimer(win.UntilEnd())
+	defer windowEnd.Stop()
+
+	bursts := 0
+	var lastPlayed time.Time
+	log.Printf("waiting for '%s' trigger", combo.Trigger)
+	for {
+		select {
+		case <-ctx.Done():
+			return bursts, ctx.Err()
+		case <-windowEnd.C():
+			return bursts, nil
+		case trigger := <-sp.triggers:
+			if trigger != combo.Trigger {
+				continue
+			}
+			now := sp.time.Now()
+			if bursts > 0 && now.Sub(lastPlayed) < cooldown {
+				log.Printf("ignoring '%s' trigger, last played %s ago", trigger, now.Sub(lastPlayed))
+				continue
+			}
+			lastPlayed = now
+			bursts++
+			if err := sp.playSounds(ctx, combo, soundChooser, trigger); err != nil {
+				return bursts, err
+			}
 		}
 	}
 }
 
 const hourMinuteFormat = "15:04"
 
-// createWindow creates a window with the times specified in the combo definition
+// createWindow creates a window with the times specified in the combo definition.
+// Times relative to the sun are resolved for today's date.
 func (sp SchedulePlayer) createWindow(combo Combo) *window.Window {
-	from := combo.From.Time.Format(hourMinuteFormat)
-	to := combo.Until.Time.Format(hourMinuteFormat)
+	from := sp.resolveTimeOfDay(combo.From).Format(hourMinuteFormat)
+	to := sp.resolveTimeOfDay(combo.Until).Format(hourMinuteFormat)
 	win, _ := window.New(from, to, 0, 0)
 	win.Now = sp.time.Now
 	return win
 }
 
-// playSounds plays the sounds for a combo.
-func (sp SchedulePlayer) playSounds(combo Combo, chooser *SoundChooser) {
+// resolveTimeOfDay works out the clock time of a time of day for today.
+func (sp SchedulePlayer) resolveTimeOfDay(t TimeOfDay) time.Time {
+	return resolveOn(t, sp.time.Now(), sp.location)
+}
+
+// resolveOn works out the clock time of a time of day on the given day,
+// falling back to midnight if the sun does not rise or set that day.
+func resolveOn(t TimeOfDay, day time.Time, location Location) time.Time {
+	resolved, err := t.On(day, location)
+	if err != nil {
+		log.Printf("could not resolve %s: %v (using midnight)", t, err)
+		return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location()).Add(t.Offset)
+	}
+	return resolved
+}
+
+// playSounds plays the sounds for a combo. A sound that has started is
+// always played to the end, cancelling the context stops the sounds after it.
+// The trigger that caused the sounds to be played, if any, is added to their events.
+func (sp SchedulePlayer) playSounds(ctx context.Context, combo Combo, chooser *SoundChooser, trigger string) error {
 	log.Print("Starting sound burst")
 	for count := 0; count < len(combo.Sounds); count++ {
-		sp.time.Wait(time.Duration(combo.Waits[count]) * time.Second)
+		if err := Wait(ctx, sp.time, time.Duration(combo.Waits[count])*time.Second); err != nil {
+			return err
+		}
 		file_id, soundFilename := chooser.ChooseSound(combo.Sounds[count])
 		if file_id > 0 {
 			volume := combo.Volumes[count]
 			now := sp.time.Now()
+			if sp.plan != nil {
+				sp.plan.add(now, combo.Sounds[count], volume)
+				continue
+			}
 			log.Printf("Playing sound %s at volume level %d", soundFilename, volume)
 			event := &eventclient.Event{
-				Type: "audioBait",
+				Type:    "audioBait",
+				Details: map[string]interface{}{"source": audiobaitclient.SourceSchedule},
 			}
-			if played, err := audiobaitclientPlay(file_id, volume, 1, event); err != nil {
+			if trigger != "" {
+				event.Details["source"] = audiobaitclient.SourceTrigger
+				event.Details["trigger"] = trigger
+			}
+			if played, reason, err := audiobaitclientPlay(file_id, volume, 1, event); err != nil {
 				log.Printf("Play failed: %v", err)
+				sp.recordPlayFailed(now, file_id, volume, err.Error())
 			} else if !played {
-				log.Println("audiobait was not played because it's priority wasn't high enough")
+				log.Printf("audiobait was not played: %s", reason)
+				sp.recordPlayFailed(now, file_id, volume, reason)
 			} else if sp.recorder != nil {
 				sp.recorder.OnAudioBaitPlayed(now, file_id, volume)
 			}
 		} else {
 			log.Printf("Could not play %s.  Either sound does not exist or this option cannot be parsed.", combo.Sounds[count])
+			sp.recordPlayFailed(sp.time.Now(), 0, combo.Volumes[count], "could not choose a sound for '"+combo.Sounds[count]+"'")
 		}
 	}
+	return nil
+}
+
+func (sp SchedulePlayer) recordPlayFailed(ts time.Time, fileId, volume int, reason string) {
+	if sp.recorder != nil {
+		sp.recorder.OnPlayFailed(ts, fileId, volume, reason)
+	}
 }
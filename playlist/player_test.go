@@ -19,6 +19,7 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package playlist
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -31,6 +32,7 @@ import (
 
 var fakePlayerSuccess = true
 var fakePlayerError error = nil
+var fakePlayerReason = ""
 
 var soundFiles = map[int]string{
 	1: "squeal",
@@ -41,16 +43,35 @@ var soundFiles = map[int]string{
 type TestClockAndAudioDevice struct {
 	NowTime   time.Time
 	PlayTimes []string
+	Nights    []CalendarNight
+	Skipped   []int
+	Failures  []string
+	playedAt  []time.Time
 }
 
 func (t *TestClockAndAudioDevice) Now() time.Time {
 	return t.NowTime
 }
 
-func (t *TestClockAndAudioDevice) Wait(duration time.Duration) {
+// After doesn't wait, it moves the time on by the duration straight away.
+func (t *TestClockAndAudioDevice) After(duration time.Duration) <-chan time.Time {
+	return t.NewTimer(duration).C()
+}
+
+func (t *TestClockAndAudioDevice) NewTimer(duration time.Duration) Timer {
 	t.NowTime = t.NowTime.Add(duration).Add(time.Microsecond)
+	c := make(chan time.Time, 1)
+	c <- t.NowTime
+	return firedTimer(c)
 }
 
+// firedTimer is a timer that has already fired.
+type firedTimer chan time.Time
+
+func (t firedTimer) C() <-chan time.Time        { return t }
+func (t firedTimer) Stop() bool                 { return false }
+func (t firedTimer) Reset(d time.Duration) bool { return false }
+
 func (t *TestClockAndAudioDevice) SetDay(day int, month time.Month) {
 	now := t.NowTime
 	t.NowTime = time.Date(1, month, day, now.Hour(), now.Minute(), now.Second(), 0, time.UTC)
@@ -60,17 +81,37 @@ func (t *TestClockAndAudioDevice) OnAudioBaitPlayed(ts time.Time, fileId int, vo
 	nowTimeAsString := fmt.Sprintf("%02d:%02d:%02d", ts.Hour(), ts.Minute(), ts.Second())
 	playingString := registerPlaySound(nowTimeAsString, soundFiles[fileId])
 	t.PlayTimes = append(t.PlayTimes, playingString)
+	t.playedAt = append(t.playedAt, ts)
 	fmt.Println(playingString)
 }
 
+func (t *TestClockAndAudioDevice) OnPlayNightStart(ts time.Time, schedule Schedule, night CalendarNight) {
+	t.Nights = append(t.Nights, night)
+}
+
+func (t *TestClockAndAudioDevice) OnControlNightStart(ts time.Time, schedule Schedule, night CalendarNight) {
+	t.Nights = append(t.Nights, night)
+}
+
+func (t *TestClockAndAudioDevice) OnComboSkipped(ts time.Time, comboIndex int, reason string) {
+	t.Skipped = append(t.Skipped, comboIndex)
+}
+
+func (t *TestClockAndAudioDevice) OnPlayFailed(ts time.Time, fileId int, volume int, reason string) {
+	t.Failures = append(t.Failures, reason)
+}
+
 func registerPlaySound(playTime, audioFileName string) string {
 	return fmt.Sprintf("%s: Playing %s", playTime, audioFileName)
 }
 
 func createPlayer(startTime string) (*SchedulePlayer, *TestClockAndAudioDevice) {
+	fakePlayerSuccess = true
+	fakePlayerError = nil
+	fakePlayerReason = ""
 	audiobaitclientPlay =
-		func(int, int, int, *eventclient.Event) (bool, error) {
-			return fakePlayerSuccess, fakePlayerError
+		func(int, int, int, *eventclient.Event) (bool, string, error) {
+			return fakePlayerSuccess, fakePlayerReason, fakePlayerError
 		}
 	testPlayerAndTimer := new(TestClockAndAudioDevice)
 	testPlayerAndTimer.PlayTimes = make([]string, 0, 10)
@@ -84,7 +125,7 @@ func TestPlayingComboStartDuring(t *testing.T) {
 	combo := createCombo("12:01", "13:03", 30, "beep")
 
 	schedulePlayer, testRecorder := createPlayer("12:13")
-	schedulePlayer.playCombo(combo)
+	schedulePlayer.playCombo(context.Background(), combo)
 
 	expectedPlayTimes := []string{
 		registerPlaySound("12:31:00", "beep"),
@@ -98,7 +139,7 @@ func TestPlayingComboStartBefore(t *testing.T) {
 	combo := createCombo("12:01", "13:03", 30, "howl")
 
 	schedulePlayer, testRecorder := createPlayer("11:21")
-	schedulePlayer.playCombo(combo)
+	schedulePlayer.playCombo(context.Background(), combo)
 
 	expectedPlayTimes := []string{
 		registerPlaySound("12:01:00", "howl"),
@@ -109,6 +150,25 @@ func TestPlayingComboStartBefore(t *testing.T) {
 	assert.Equal(t, testRecorder.PlayTimes, expectedPlayTimes)
 }
 
+func TestPlayingComboRelativeToSunset(t *testing.T) {
+	combo := createCombo("12:00", "13:00", 30, "chirp")
+	combo.From = *NewTimeOfDay("sunset+00:30")
+	combo.Until = *NewTimeOfDay("sunset+01:30")
+
+	schedulePlayer, testRecorder := createPlayer("12:00")
+	schedulePlayer.SetLocation(Location{Latitude: -43.5321, Longitude: 172.6362})
+	testRecorder.NowTime = time.Date(2021, time.June, 21, 16, 0, 0, 0, time.FixedZone("NZST", 12*60*60))
+	schedulePlayer.playCombo(context.Background(), combo)
+
+	// Sunset in Christchurch is at 16:59 on the winter solstice.
+	expectedPlayTimes := []string{
+		registerPlaySound("17:29:00", "chirp"),
+		registerPlaySound("17:59:00", "chirp"),
+	}
+
+	assert.Equal(t, expectedPlayTimes, testRecorder.PlayTimes)
+}
+
 func TestPlayTodaysScheduleWithComboOverMiddayShouldPlayToEndOfComboThenStop(t *testing.T) {
 	combos := []Combo{
 		createCombo("19:00", "19:25", 30, "roar"),
@@ -116,7 +176,7 @@ func TestPlayTodaysScheduleWithComboOverMiddayShouldPlayToEndOfComboThenStop(t *
 	}
 
 	schedulePlayer, testRecorder := createPlayer("18:30")
-	schedulePlayer.playTodaysCombos(combos)
+	schedulePlayer.playTodaysCombos(context.Background(), Schedule{Combos: combos})
 
 	expectedPlayTimes := []string{
 		registerPlaySound("19:00:00", "roar"),
@@ -134,7 +194,7 @@ func TestPlayTodaysScheduleShouldLoopBackToStartOfCombosIfRequired(t *testing.T)
 	}
 
 	schedulePlayer, testRecorder := createPlayer("18:30")
-	schedulePlayer.playTodaysCombos(combos)
+	schedulePlayer.playTodaysCombos(context.Background(), Schedule{Combos: combos})
 
 	expectedPlayTimes := []string{
 		registerPlaySound("21:12:00", "tweet"),
@@ -145,6 +205,87 @@ func TestPlayTodaysScheduleShouldLoopBackToStartOfCombosIfRequired(t *testing.T)
 	assert.Equal(t, testRecorder.PlayTimes, expectedPlayTimes)
 }
 
+func TestPlayTodaysScheduleWithConfiguredChangeoverPlaysComboStraddlingIt(t *testing.T) {
+	schedule := Schedule{
+		DayChangeover: *NewTimeOfDay("18:00"),
+		Combos: []Combo{
+			createCombo("19:00", "19:25", 30, "roar"),
+			createCombo("17:30", "18:40", 30, "cry"),
+			createCombo("18:10", "18:50", 30, "late"),
+		},
+	}
+
+	schedulePlayer, testRecorder := createPlayer("18:55")
+	schedulePlayer.playTodaysCombos(context.Background(), schedule)
+
+	// "cry" starts before the changeover so it belongs to today and plays to the end.
+	// "late" starts after the changeover so it belongs to tomorrow.
+	expectedPlayTimes := []string{
+		registerPlaySound("19:00:00", "roar"),
+		registerPlaySound("17:30:00", "cry"),
+		registerPlaySound("18:00:00", "cry"),
+		registerPlaySound("18:30:00", "cry"),
+	}
+
+	assert.Equal(t, expectedPlayTimes, testRecorder.PlayTimes)
+}
+
+func TestControlDayWaitsUntilConfiguredChangeover(t *testing.T) {
+	schedule := Schedule{
+		ControlNights: 1,
+		PlayNights:    1,
+		StartDay:      2,
+		DayChangeover: *NewTimeOfDay("06:00"),
+		Combos:        []Combo{createCombo("21:00", "22:00", 30, "foo")},
+	}
+	schedulePlayer, clock := createPlayer("05:00")
+
+	// 05:00 on the 3rd is still the 2nd's audiobait day, which plays.
+	checkPlaysOn(3, time.April, t, schedule, schedulePlayer, clock)
+	clock.NowTime = clock.NowTime.Add(2 * time.Hour)
+	checkSilentOn(3, time.April, t, schedule, schedulePlayer, clock)
+	assert.Equal(t, 23*time.Hour, schedulePlayer.TimeUntilNextCombo(schedule))
+}
+
+func TestDayChangeoverRelativeToSunset(t *testing.T) {
+	nz := time.FixedZone("NZST", 12*60*60)
+	christchurch := Location{Latitude: -43.5321, Longitude: 172.6362}
+	sunset := *NewTimeOfDay("sunset")
+
+	start, next := dayBounds(time.Date(2021, time.June, 21, 20, 0, 0, 0, nz), sunset, christchurch)
+	assert.Equal(t, "2021-06-21 16:59", start.Format("2006-01-02 15:04"))
+	assert.Equal(t, "2021-06-22 16:59", next.Format("2006-01-02 15:04"))
+
+	start, next = dayBounds(time.Date(2021, time.June, 21, 9, 0, 0, 0, nz), sunset, christchurch)
+	assert.Equal(t, "2021-06-20 16:59", start.Format("2006-01-02 15:04"))
+	assert.Equal(t, "2021-06-21 16:59", next.Format("2006-01-02 15:04"))
+}
+
+func TestControlNightIsRecorded(t *testing.T) {
+	schedule := Schedule{
+		PlayNights:    1,
+		ControlNights: 1,
+		CycleStart:    NewDate(1, time.April, 1),
+		Combos:        []Combo{createCombo("19:00", "20:00", 30, "foo")},
+	}
+	schedulePlayer, clock := createPlayer("17:01")
+	clock.SetDay(2, time.April)
+	schedulePlayer.PlayTodaysSchedule(context.Background(), schedule)
+
+	assert.Empty(t, clock.PlayTimes)
+	assert.Len(t, clock.Nights, 1)
+	assert.False(t, clock.Nights[0].Play)
+}
+
+func TestComboWithNoBurstsLeftIsRecordedAsSkipped(t *testing.T) {
+	schedule := Schedule{Combos: []Combo{createCombo("12:01", "12:40", 30, "foo")}}
+	schedulePlayer, clock := createPlayer("12:35")
+	schedulePlayer.playTodaysCombos(context.Background(), schedule)
+
+	assert.Empty(t, clock.PlayTimes)
+	assert.Equal(t, []int{0}, clock.Skipped)
+}
+
 func TestScheduleWithZeroControlNightsAlwaysPlays(t *testing.T) {
 	schedule := Schedule{
 		ControlNights: 0,
@@ -226,7 +367,7 @@ func TestPlayComboWithMultipleSoundsIncludingSame(t *testing.T) {
 	addAnotherSound(&combos[0], 2, "meow")
 
 	schedulePlayer, testRecorder := createPlayer("17:59")
-	schedulePlayer.playTodaysCombos(combos)
+	schedulePlayer.playTodaysCombos(context.Background(), Schedule{Combos: combos})
 
 	expectedPlayTimes := []string{
 		registerPlaySound("18:00:00", "roar"),
@@ -247,16 +388,57 @@ func TestFindNextCombo(t *testing.T) {
 	fmt.Print(combos[schedulePlayer.findNextCombo(combos)])
 }
 
+func TestNextCombo(t *testing.T) {
+	schedule := Schedule{Combos: []Combo{
+		createCombo("12:03", "15:08", 30, "a"),
+		createCombo("17:12", "02:15", 45, "b"),
+	}}
+	schedulePlayer, clock := createPlayer("12:13")
+	index, start, active := schedulePlayer.NextCombo(schedule)
+	assert.Equal(t, 0, index)
+	assert.True(t, active)
+	assert.Equal(t, "12:03", start.Format(hourMinuteFormat))
+	assert.True(t, start.Before(clock.Now()))
+
+	clock.NowTime = NewTimeOfDay("16:00").Time
+	index, start, active = schedulePlayer.NextCombo(schedule)
+	assert.Equal(t, 1, index)
+	assert.False(t, active)
+	assert.Equal(t, "17:12", start.Format(hourMinuteFormat))
+	assert.True(t, start.After(clock.Now()))
+}
+
+func TestCancellingStopsBetweenSounds(t *testing.T) {
+	combo := createCombo("12:01", "13:03", 30, "roar")
+	addAnotherSound(&combo, 3, "same")
+	addAnotherSound(&combo, 2, "meow")
+
+	schedulePlayer, testRecorder := createPlayer("11:59")
+	ctx, cancel := context.WithCancel(context.Background())
+	audiobaitclientPlay = func(int, int, int, *eventclient.Event) (bool, string, error) {
+		// The sound that is playing when the context is cancelled still finishes.
+		cancel()
+		return true, "", nil
+	}
+	bursts, err := schedulePlayer.playCombo(ctx, combo)
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, bursts)
+	assert.Equal(t, []string{registerPlaySound("12:01:00", "roar")}, testRecorder.PlayTimes)
+}
+
 func TestRecorderIsNotCalledWhenSoundIsNotPlayed(t *testing.T) {
 	combo := createCombo("12:01", "13:03", 30, "howl")
 
 	schedulePlayer, testRecorder := createPlayer("12:10")
 	fakePlayerSuccess = false
-	schedulePlayer.playCombo(combo)
+	fakePlayerReason = "a higher priority sound is playing"
+	schedulePlayer.playCombo(context.Background(), combo)
 
 	expectedPlayedTimes := []string{}
 
 	assert.Equal(t, expectedPlayedTimes, testRecorder.PlayTimes)
+	assert.Equal(t, []string{"a higher priority sound is playing", "a higher priority sound is playing"}, testRecorder.Failures)
 }
 
 func TestRecorderIsNotCalledWhenErrorWithPlayingSound(t *testing.T) {
@@ -264,11 +446,12 @@ func TestRecorderIsNotCalledWhenErrorWithPlayingSound(t *testing.T) {
 
 	schedulePlayer, testRecorder := createPlayer("12:10")
 	fakePlayerError = errors.New("some error with playing audio")
-	schedulePlayer.playCombo(combo)
+	schedulePlayer.playCombo(context.Background(), combo)
 
 	expectedPlayedTimes := []string{}
 
 	assert.Equal(t, expectedPlayedTimes, testRecorder.PlayTimes)
+	assert.Equal(t, []string{"some error with playing audio", "some error with playing audio"}, testRecorder.Failures)
 }
 
 func createCombo(timeStart, timeEnd string, everyMinutes int, soundName string) Combo {
@@ -298,3 +481,133 @@ func makeSoundNameForSchedule(soundName string) string {
 	}
 	return scheduleIdentifier
 }
+
+// runOnFakeClock runs f, moving the clock on to each timer as soon as it is
+// waited on, until f returns.
+func runOnFakeClock(clock *FakeClock, f func()) {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if !clock.AdvanceToNext() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestMultiDayRunOnFakeClock(t *testing.T) {
+	fakePlayerSuccess = true
+	fakePlayerError = nil
+	fakePlayerReason = ""
+	audiobaitclientPlay =
+		func(int, int, int, *eventclient.Event) (bool, string, error) {
+			return fakePlayerSuccess, fakePlayerReason, fakePlayerError
+		}
+	clock := NewFakeClock(time.Date(2019, time.January, 1, 13, 0, 0, 0, time.UTC))
+	recorder := new(TestClockAndAudioDevice)
+	schedulePlayer := NewPlayer(soundFiles, "")
+	schedulePlayer.SetClock(clock)
+	schedulePlayer.SetRecorder(recorder)
+
+	schedule := Schedule{
+		PlayNights:    1,
+		ControlNights: 1,
+		CycleStart:    NewDate(2019, time.January, 1),
+		Combos:        []Combo{createCombo("19:00", "19:25", 10, "tweet")},
+	}
+
+	runOnFakeClock(clock, func() {
+		for len(recorder.Nights) < 4 {
+			ctx := context.Background()
+			if err := Wait(ctx, clock, schedulePlayer.TimeUntilNextCombo(schedule)); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := schedulePlayer.PlayTodaysSchedule(ctx, schedule); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+
+	var plays []bool
+	for _, night := range recorder.Nights {
+		plays = append(plays, night.Play)
+	}
+	assert.Equal(t, []bool{true, false, true, false}, plays)
+	assert.Equal(t, []string{
+		registerPlaySound("19:00:00", "tweet"),
+		registerPlaySound("19:10:00", "tweet"),
+		registerPlaySound("19:20:00", "tweet"),
+		registerPlaySound("19:00:00", "tweet"),
+		registerPlaySound("19:10:00", "tweet"),
+		registerPlaySound("19:20:00", "tweet"),
+	}, recorder.PlayTimes)
+	assert.Equal(t, NewDate(2019, time.January, 4), recorder.Nights[3].Date)
+}
+
+func TestTriggeredComboPlaysOnTriggerWithCooldown(t *testing.T) {
+	played := make(chan *eventclient.Event, 1)
+	audiobaitclientPlay =
+		func(fileId, volume, priority int, event *eventclient.Event) (bool, string, error) {
+			played <- event
+			return true, "", nil
+		}
+	clock := NewFakeClock(time.Date(2019, time.January, 1, 18, 0, 0, 0, time.UTC))
+	recorder := new(TestClockAndAudioDevice)
+	triggers := make(chan string)
+	schedulePlayer := NewPlayer(soundFiles, "")
+	schedulePlayer.SetClock(clock)
+	schedulePlayer.SetRecorder(recorder)
+	schedulePlayer.SetTriggers(triggers)
+
+	combo := createCombo("19:00", "20:00", 5, "tweet")
+	combo.Trigger = "Tracking"
+	done := make(chan int)
+	go func() {
+		bursts, err := schedulePlayer.playTriggeredCombo(context.Background(), combo)
+		assert.NoError(t, err)
+		done <- bursts
+	}()
+
+	// Triggers are only listened for once the window starts.
+	clock.BlockUntil(1)
+	clock.AdvanceToNext()
+	clock.BlockUntil(1)
+
+	triggers <- "Tracking"
+	event := <-played
+	assert.Equal(t, "Tracking", event.Details["trigger"])
+	clock.Advance(time.Minute)
+	triggers <- "Tracking"
+	triggers <- "Other"
+	clock.Advance(5 * time.Minute)
+	triggers <- "Tracking"
+	<-played
+	clock.AdvanceToNext()
+
+	assert.Equal(t, 2, <-done)
+	assert.Equal(t, []string{
+		registerPlaySound("19:00:00", "tweet"),
+		registerPlaySound("19:06:00", "tweet"),
+	}, recorder.PlayTimes)
+	assert.Equal(t, time.Date(2019, time.January, 1, 20, 0, 0, 0, time.UTC), clock.Now())
+}
+
+func TestTriggeredComboWithoutTriggerIsRecordedAsSkipped(t *testing.T) {
+	schedulePlayer, testRecorder := createPlayer("18:00")
+	combo := createCombo("19:00", "20:00", 5, "tweet")
+	combo.Trigger = "Tracking"
+	schedule := Schedule{Combos: []Combo{combo}}
+
+	assert.NoError(t, schedulePlayer.playTodaysCombos(context.Background(), schedule))
+	assert.Empty(t, testRecorder.PlayTimes)
+	assert.Equal(t, []int{0}, testRecorder.Skipped)
+}
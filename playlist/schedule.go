@@ -22,44 +22,70 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"time"
 
 	"github.com/TheCacophonyProject/go-api"
 )
 
 const (
 	ScheduleFilename = "schedule.json"
+
+	defaultDayChangeover = "12:00"
 )
 
 type Schedule struct {
 	Description   string
 	ControlNights int
 	PlayNights    int
-	StartDay      int
+	// StartDay is the legacy day of the month that the cycle started on.
+	// It is only used to work out CycleStart for schedules that don't have one.
+	StartDay int
+	// CycleStart is the date of the first play night of the play/control cycle.
+	CycleStart Date
+	// Design is how play and control nights are assigned, DesignAlternating
+	// (the default) or DesignRandomBlock.
+	Design string
+	// Seed makes the random assignment of nights reproducible.
+	Seed int64
+	// DayChangeover is when one audiobait day ends and the next begins.
+	// Defaults to midday if not set.
+	DayChangeover TimeOfDay
 	Combos        []Combo
 	AllSounds     []int
 }
 
 type Combo struct {
-	From    TimeOfDay
+	From TimeOfDay
+	// Every is the seconds between bursts of sounds, or for a combo with a
+	// Trigger the least time between bursts.
 	Every   int
 	Until   TimeOfDay
 	Waits   []int
 	Volumes []int
 	Sounds  []string
+	// Trigger, if set, is the name of the trigger that plays the combo. The combo
+	// is armed between From and Until but only plays when the trigger fires.
 	Trigger string
 }
 
+// SaveScheduleIfNew writes the schedule to disk if it differs from the one already there.
+// An invalid schedule is refused so the last good schedule is kept.
 func SaveScheduleIfNew(audioDir string, newSchedule *Schedule) (new bool, err error) {
-	oldSchedule, err := LoadScheduleFromDisk(audioDir)
+	if errs := newSchedule.Validate(); errs != nil {
+		return false, errs
+	}
+	oldSchedule, migrated, err := loadSchedule(audioDir)
+	newSchedule.migrateCycleStart(oldSchedule, newSchedule.dayStart(time.Now()))
 	if err != nil {
 		log.Printf("error loading old schedule so saving new schedule '%v'\n", err)
 		return true, saveScheduleToDisk(audioDir, newSchedule)
 	}
-	if !reflect.DeepEqual(oldSchedule, newSchedule) {
+	if migrated || !reflect.DeepEqual(oldSchedule, newSchedule) {
 		log.Println("saving new schedule to disk")
 		return true, saveScheduleToDisk(audioDir, newSchedule)
 	}
@@ -81,7 +107,14 @@ func GetScheduleFromAPI(api *api.CacophonyAPI) (*Schedule, error) {
 	if err != nil {
 		return nil, err
 	}
-	return serverResponseToSchedule(responseBytes)
+	schedule, err := serverResponseToSchedule(responseBytes)
+	if err != nil {
+		return nil, err
+	}
+	if errs := schedule.Validate(); errs != nil {
+		return nil, errs
+	}
+	return schedule, nil
 }
 
 func serverResponseToSchedule(bytes []byte) (*Schedule, error) {
@@ -95,12 +128,49 @@ func serverResponseToSchedule(bytes []byte) (*Schedule, error) {
 	return &sr.Schedule, nil
 }
 
+// LoadScheduleFromDisk loads the schedule to play from audioDir. A legacy
+// schedule that only has a StartDay is anchored to the StartDay of the month
+// the schedule was saved in, so its cycle doesn't restart every month.
 func LoadScheduleFromDisk(audioDir string) (*Schedule, error) {
-	rawData, err := ioutil.ReadFile(path.Join(audioDir, ScheduleFilename))
+	schedule, _, err := loadSchedule(audioDir)
+	return schedule, err
+}
+
+// loadSchedule loads the schedule in audioDir, also returning whether it was a
+// legacy schedule that needed a CycleStart.
+func loadSchedule(audioDir string) (*Schedule, bool, error) {
+	filename := path.Join(audioDir, ScheduleFilename)
+	schedule, err := ReadSchedule(audioDir)
+	if err != nil {
+		return nil, false, err
+	}
+	if !schedule.CycleStart.IsZero() {
+		return schedule, false, nil
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	schedule.migrateCycleStart(nil, schedule.dayStart(stat.ModTime()))
+	return schedule, true, nil
+}
+
+// ReadSchedule reads and validates the schedule in dir as it is written. Unlike
+// LoadScheduleFromDisk a legacy StartDay isn't anchored to a CycleStart, which
+// is left for SaveScheduleIfNew to do.
+func ReadSchedule(dir string) (*Schedule, error) {
+	rawData, err := ioutil.ReadFile(path.Join(dir, ScheduleFilename))
 	if err != nil {
 		return nil, err
 	}
-	return bytesToSchedule(rawData)
+	schedule, err := bytesToSchedule(rawData)
+	if err != nil {
+		return nil, err
+	}
+	if errs := schedule.Validate(); errs != nil {
+		return nil, errs
+	}
+	return schedule, nil
 }
 
 func bytesToSchedule(bytes []byte) (*Schedule, error) {
@@ -136,6 +206,14 @@ func (schedule *Schedule) GetReferencedSounds() []int {
 	return ids[:i]
 }
 
+// dayChangeover returns the time of day when the schedule's audiobait days start.
+func (schedule *Schedule) dayChangeover() TimeOfDay {
+	if schedule.DayChangeover.isValid() {
+		return schedule.DayChangeover
+	}
+	return *NewTimeOfDay(defaultDayChangeover)
+}
+
 // CycleLength calculates how many days the play-control cycle is.
 func (schedule *Schedule) CycleLength() int {
 	cycle := schedule.PlayNights + schedule.ControlNights
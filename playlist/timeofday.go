@@ -19,34 +19,148 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package playlist
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	sunrise "github.com/nathan-osman/go-sunrise"
+)
+
+const (
+	Sunrise = "sunrise"
+	Sunset  = "sunset"
 )
 
+// TimeOfDay is either a clock time ("21:30") or a time relative to sunrise or
+// sunset ("sunset+00:30", "sunrise-01:00").
 type TimeOfDay struct {
 	time.Time
+	// SunEvent is Sunrise or Sunset when the time is relative to the sun.
+	SunEvent string
+	// Offset is added to the SunEvent time.
+	Offset time.Duration
+	// unparsed is the text of a time that couldn't be parsed, so that
+	// Schedule.Validate can report it.
+	unparsed string
+}
+
+// Location is where the device is, used to work out sunrise and sunset times.
+type Location struct {
+	Latitude  float64
+	Longitude float64
 }
 
 const timeLayout = `15:04`
-const timeLayoutJson = `"` + timeLayout + `"`
+
+var errNoSunEvent = errors.New("the sun does not rise or set on this day")
 
 func (timeOfDay *TimeOfDay) UnmarshalJSON(bValue []byte) (err error) {
 	sValue := string(bValue)
 	if sValue == "null" {
-		timeOfDay.Time = time.Time{}
+		*timeOfDay = TimeOfDay{}
+		return
+	}
+	var s string
+	if err = json.Unmarshal(bValue, &s); err != nil {
 		return
 	}
-	timeOfDay.Time, err = time.ParseInLocation(timeLayoutJson, sValue, &time.Location{})
-	return
+	// A time that can't be parsed is left for Validate to report, along with
+	// any other problems with the schedule.
+	if *timeOfDay, err = parseTimeOfDay(s); err != nil {
+		*timeOfDay = TimeOfDay{unparsed: s}
+	}
+	return nil
 }
 
 func (t *TimeOfDay) MarshalJSON() ([]byte, error) {
-	return []byte("\"" + t.Time.Format(timeLayout) + "\""), nil
+	if !t.isValid() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.String())
+}
+
+func (t TimeOfDay) String() string {
+	if t.SunEvent == "" {
+		return t.Time.Format(timeLayout)
+	}
+	if t.Offset == 0 {
+		return t.SunEvent
+	}
+	sign, offset := "+", t.Offset
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return fmt.Sprintf("%s%s%02d:%02d", t.SunEvent, sign, int(offset.Hours()), int(offset.Minutes())%60)
 }
 
 func NewTimeOfDay(timeOfDayString string) *TimeOfDay {
-	t, err := time.ParseInLocation(timeLayout, timeOfDayString, &time.Location{})
+	t, err := parseTimeOfDay(timeOfDayString)
 	if err != nil {
-		t = time.Time{}
+		t = TimeOfDay{}
+	}
+	return &t
+}
+
+func parseTimeOfDay(s string) (TimeOfDay, error) {
+	for _, event := range []string{Sunrise, Sunset} {
+		if !strings.HasPrefix(s, event) {
+			continue
+		}
+		rest := s[len(event):]
+		if rest == "" {
+			return TimeOfDay{SunEvent: event}, nil
+		}
+		if rest[0] != '+' && rest[0] != '-' {
+			return TimeOfDay{}, fmt.Errorf("could not parse '%s' as a time relative to %s", s, event)
+		}
+		offset, err := time.Parse(timeLayout, rest[1:])
+		if err != nil {
+			return TimeOfDay{}, fmt.Errorf("could not parse offset in '%s': %v", s, err)
+		}
+		d := time.Duration(offset.Hour())*time.Hour + time.Duration(offset.Minute())*time.Minute
+		if rest[0] == '-' {
+			d = -d
+		}
+		return TimeOfDay{SunEvent: event, Offset: d}, nil
+	}
+
+	t, err := time.ParseInLocation(timeLayout, s, &time.Location{})
+	if err != nil {
+		return TimeOfDay{}, err
+	}
+	return TimeOfDay{Time: t}, nil
+}
+
+// parseError returns why the time of day couldn't be parsed, or nil if it was.
+func (t TimeOfDay) parseError() error {
+	if t.unparsed == "" {
+		return nil
+	}
+	_, err := parseTimeOfDay(t.unparsed)
+	return err
+}
+
+// isValid reports whether the time of day was set and parsed correctly.
+// A failed parse leaves the zero time, which is distinct from "00:00".
+func (t TimeOfDay) isValid() bool {
+	return t.SunEvent != "" || !t.Time.IsZero()
+}
+
+// On resolves the time of day on the date of day, in day's time zone.
+// Sun relative times are worked out for the given location.
+func (t TimeOfDay) On(day time.Time, loc Location) (time.Time, error) {
+	if t.SunEvent == "" {
+		return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, day.Location()), nil
+	}
+	rise, set := sunrise.SunriseSunset(loc.Latitude, loc.Longitude, day.Year(), day.Month(), day.Day())
+	if !rise.Before(set) {
+		return time.Time{}, errNoSunEvent
+	}
+	event := set
+	if t.SunEvent == Sunrise {
+		event = rise
 	}
-	return &TimeOfDay{Time: t}
+	return event.In(day.Location()).Add(t.Offset), nil
 }
@@ -40,6 +40,45 @@ func TestParsingValidTimeJson(t *testing.T) {
 	parseJsonShouldFail(t, "20:67")
 }
 
+func TestParsingSunRelativeTimeJson(t *testing.T) {
+	parseJsonSunTimeAndCheck(t, TimeOfDay{SunEvent: Sunset, Offset: 30 * time.Minute}, "sunset+00:30")
+	parseJsonSunTimeAndCheck(t, TimeOfDay{SunEvent: Sunrise, Offset: -90 * time.Minute}, "sunrise-01:30")
+	parseJsonSunTimeAndCheck(t, TimeOfDay{SunEvent: Sunset}, "sunset")
+	parseJsonShouldFail(t, "sunset+0030")
+	parseJsonShouldFail(t, "sunrise*01:00")
+	parseJsonShouldFail(t, "sundown")
+}
+
+func TestResolvingTimeOfDay(t *testing.T) {
+	nz := time.FixedZone("NZST", 12*60*60)
+	christchurch := Location{Latitude: -43.5321, Longitude: 172.6362}
+	day := time.Date(2021, time.June, 21, 9, 0, 0, 0, nz)
+
+	resolved, err := NewTimeOfDay("21:15").On(day, christchurch)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, time.June, 21, 21, 15, 0, 0, nz), resolved)
+
+	resolved, err = NewTimeOfDay("sunset+00:30").On(day, christchurch)
+	assert.NoError(t, err)
+	assert.Equal(t, "17:29", resolved.Format(timeLayout))
+
+	resolved, err = NewTimeOfDay("sunrise-01:00").On(day, christchurch)
+	assert.NoError(t, err)
+	assert.Equal(t, "07:02", resolved.Format(timeLayout))
+
+	_, err = NewTimeOfDay("sunset").On(day, Location{Latitude: 78, Longitude: 15})
+	assert.Equal(t, errNoSunEvent, err)
+}
+
+func parseJsonSunTimeAndCheck(t *testing.T, expected TimeOfDay, timeStr string) {
+	timeOfDay := NewTimeOfDay(timeStr)
+	assert.Equal(t, expected, *timeOfDay)
+	assert.Equal(t, timeStr, timeOfDay.String())
+	parsed, err := parseJsonTime(timeStr)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, parsed.Time)
+}
+
 func newTime(hour, minute int) time.Time {
 	return time.Date(0, 1, 1, hour, minute, 0, 0, &time.Location{})
 }
@@ -55,8 +94,10 @@ func parseJsonTimeAndCheck(t *testing.T, timeExpected time.Time, timeStr string)
 	assert.Equal(t, timeExpected, newTimeOfDay.Time)
 }
 
+// parseJsonShouldFail checks the time isn't parsed. The JSON is still read so
+// that the schedule's Validate can report the time along with any other problems.
 func parseJsonShouldFail(t *testing.T, time string) {
-	if _, err := parseJsonTime(time); err == nil {
+	if timeOfDay, err := parseJsonTime(time); err != nil || timeOfDay.Time.isValid() || timeOfDay.Time.parseError() == nil {
 		t.Errorf("Should not have parsed time correctly: %s", time)
 	} else {
 		fmt.Println("Invalid time didn't parse (this is the expected result).")
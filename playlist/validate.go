@@ -0,0 +1,151 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package playlist
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MinVolume and MaxVolume are the limits of the volume scale used in combos.
+	MinVolume = 1
+	MaxVolume = 10
+
+	// ScheduleLevel is the combo index used for problems that are not tied to a single combo.
+	ScheduleLevel = -1
+)
+
+// ValidationError describes a single problem found in a schedule.
+type ValidationError struct {
+	// Combo is the index of the combo with the problem, or ScheduleLevel.
+	Combo   int
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Combo == ScheduleLevel {
+		return fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+	return fmt.Sprintf("combo %d %s: %s", e.Combo, e.Field, e.Message)
+}
+
+// ValidationErrors is the list of problems found when validating a schedule.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return "invalid schedule: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks that the schedule can be played. It returns every problem
+// found, or nil if the schedule is valid.
+func (schedule *Schedule) Validate() ValidationErrors {
+	var errs ValidationErrors
+	add := func(combo int, field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{
+			Combo:   combo,
+			Field:   field,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if schedule.PlayNights < 0 {
+		add(ScheduleLevel, "PlayNights", "must not be negative")
+	}
+	if schedule.ControlNights < 0 {
+		add(ScheduleLevel, "ControlNights", "must not be negative")
+	}
+
+	if err := schedule.DayChangeover.parseError(); err != nil {
+		add(ScheduleLevel, "DayChangeover", "invalid time: %v", err)
+	}
+
+	switch schedule.Design {
+	case "", DesignAlternating:
+	case DesignRandomBlock:
+		if schedule.PlayNights+schedule.ControlNights < 1 {
+			add(ScheduleLevel, "Design", "random blocks need PlayNights or ControlNights")
+		}
+	default:
+		add(ScheduleLevel, "Design", "unknown design '%s'", schedule.Design)
+	}
+
+	allSounds := make(map[int]bool)
+	for _, id := range schedule.AllSounds {
+		allSounds[id] = true
+	}
+
+	for i, combo := range schedule.Combos {
+		if err := combo.From.parseError(); err != nil {
+			add(i, "From", "invalid time: %v", err)
+		} else if !combo.From.isValid() {
+			add(i, "From", "missing or invalid time")
+		}
+		if err := combo.Until.parseError(); err != nil {
+			add(i, "Until", "invalid time: %v", err)
+		} else if !combo.Until.isValid() {
+			add(i, "Until", "missing or invalid time")
+		}
+		if combo.Every <= 0 {
+			add(i, "Every", "must be greater than zero (got %d)", combo.Every)
+		}
+		if len(combo.Sounds) == 0 {
+			add(i, "Sounds", "no sounds given")
+		}
+		if len(combo.Waits) != len(combo.Sounds) {
+			add(i, "Waits", "has %d entries but there are %d sounds", len(combo.Waits), len(combo.Sounds))
+		}
+		if len(combo.Volumes) != len(combo.Sounds) {
+			add(i, "Volumes", "has %d entries but there are %d sounds", len(combo.Volumes), len(combo.Sounds))
+		}
+		for j, wait := range combo.Waits {
+			if wait < 0 {
+				add(i, "Waits", "entry %d must not be negative (got %d)", j, wait)
+			}
+		}
+		for j, volume := range combo.Volumes {
+			if volume < MinVolume || volume > MaxVolume {
+				add(i, "Volumes", "entry %d must be between %d and %d (got %d)", j, MinVolume, MaxVolume, volume)
+			}
+		}
+		for j, sound := range combo.Sounds {
+			switch sound {
+			case "random":
+				if len(schedule.AllSounds) == 0 {
+					add(i, "Sounds", "entry %d is random but AllSounds is empty", j)
+				}
+			case "same":
+			default:
+				fileID, err := strconv.Atoi(sound)
+				if err != nil {
+					add(i, "Sounds", "entry %d has unknown sound choice '%s'", j, sound)
+				} else if !allSounds[fileID] {
+					add(i, "Sounds", "entry %d references file %d which is missing from AllSounds", j, fileID)
+				}
+			}
+		}
+	}
+	return errs
+}
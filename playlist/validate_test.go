@@ -0,0 +1,136 @@
+/*
+audiobait - play sounds to lure animals for The Cacophony Project API.
+Copyright (C) 2018, The Cacophony Project
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package playlist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidScheduleHasNoErrors(t *testing.T) {
+	assert.Nil(t, expectedSchedule.Validate())
+	assert.Nil(t, (&Schedule{}).Validate())
+}
+
+func TestValidateReportsEachProblem(t *testing.T) {
+	schedule := Schedule{
+		ControlNights: -1,
+		Combos: []Combo{
+			{
+				From:    *NewTimeOfDay("19:00"),
+				Every:   0,
+				Until:   *NewTimeOfDay("25:00"),
+				Waits:   []int{0, -2},
+				Volumes: []int{5},
+				Sounds:  []string{"random", "bark"},
+			},
+			{
+				From:    *NewTimeOfDay("20:00"),
+				Every:   60,
+				Until:   *NewTimeOfDay("21:00"),
+				Waits:   []int{0},
+				Volumes: []int{11},
+				Sounds:  []string{"99"},
+			},
+		},
+	}
+
+	assert.Equal(t, ValidationErrors{
+		{Combo: ScheduleLevel, Field: "ControlNights", Message: "must not be negative"},
+		{Combo: 0, Field: "Until", Message: "missing or invalid time"},
+		{Combo: 0, Field: "Every", Message: "must be greater than zero (got 0)"},
+		{Combo: 0, Field: "Volumes", Message: "has 1 entries but there are 2 sounds"},
+		{Combo: 0, Field: "Waits", Message: "entry 1 must not be negative (got -2)"},
+		{Combo: 0, Field: "Sounds", Message: "entry 0 is random but AllSounds is empty"},
+		{Combo: 0, Field: "Sounds", Message: "entry 1 has unknown sound choice 'bark'"},
+		{Combo: 1, Field: "Volumes", Message: "entry 0 must be between 1 and 10 (got 11)"},
+		{Combo: 1, Field: "Sounds", Message: "entry 0 references file 99 which is missing from AllSounds"},
+	}, schedule.Validate())
+}
+
+func TestInvalidScheduleIsNotSaved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiobait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	good := expectedSchedule
+	isNew, err := SaveScheduleIfNew(dir, &good)
+	require.NoError(t, err)
+	assert.True(t, isNew)
+
+	bad := expectedSchedule
+	bad.Combos = []Combo{{Every: 60, Sounds: []string{"212"}}}
+	isNew, err = SaveScheduleIfNew(dir, &bad)
+	assert.IsType(t, ValidationErrors{}, err)
+	assert.False(t, isNew)
+
+	onDisk, err := LoadScheduleFromDisk(dir)
+	require.NoError(t, err)
+	assert.Equal(t, good, *onDisk)
+}
+
+func TestInvalidScheduleOnDiskIsRejected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiobait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	raw := `{"combos": [{"from": "19:00", "until": "20:00", "every": 60, "sounds": ["1"], "waits": [], "volumes": [5]}], "allsounds": [1]}`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ScheduleFilename), []byte(raw), 0644))
+
+	_, err = LoadScheduleFromDisk(dir)
+	assert.IsType(t, ValidationErrors{}, err)
+}
+
+func TestValidateDesign(t *testing.T) {
+	schedule := Schedule{Design: "latinSquare"}
+	assert.Equal(t, ValidationErrors{
+		{Combo: ScheduleLevel, Field: "Design", Message: "unknown design 'latinSquare'"},
+	}, schedule.Validate())
+
+	schedule = Schedule{Design: DesignRandomBlock, PlayNights: 3, ControlNights: 3}
+	assert.Nil(t, schedule.Validate())
+}
+
+func TestUnparseableTimesAreValidationErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audiobait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	raw := `{"playNights": -1, "allsounds": [1], "combos": [
+		{"from": "19:00", "until": "20:00", "every": 60, "sounds": ["1"], "waits": [0], "volumes": [5]},
+		{"from": "25:99", "until": "sunrisex", "every": 60, "sounds": ["1"], "waits": [0], "volumes": [5]}]}`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ScheduleFilename), []byte(raw), 0644))
+
+	_, err = LoadScheduleFromDisk(dir)
+	require.IsType(t, ValidationErrors{}, err)
+	errs := err.(ValidationErrors)
+	require.Len(t, errs, 3)
+	assert.Equal(t, ValidationError{Combo: ScheduleLevel, Field: "PlayNights", Message: "must not be negative"}, errs[0])
+	assert.Equal(t, 1, errs[1].Combo)
+	assert.Equal(t, "From", errs[1].Field)
+	assert.Contains(t, errs[1].Message, "25:99")
+	assert.Equal(t, 1, errs[2].Combo)
+	assert.Equal(t, "Until", errs[2].Field)
+	assert.Contains(t, errs[2].Message, "sunrisex")
+}